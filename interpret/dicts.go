@@ -0,0 +1,116 @@
+package radicle
+
+import (
+	"github.com/mossid/dr-alice/types"
+)
+
+// Dict primitives never modify their arguments; updates return new dicts.
+func dictPrimFns() []PrimOp {
+	return []PrimOp{
+		PrimOp{"lookup", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			res, ok := args[1].(*Dict).Get(args[0])
+			if !ok {
+				return nil, nil, &OtherError{"lookup", "key did not exist: " + args[0].String()}
+			}
+			return s, res, nil
+		}}.argn(2).types(TypeNULL, TypeDict),
+		PrimOp{"lookup-default", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			res, ok := args[2].(*Dict).Get(args[0])
+			if !ok {
+				return s, args[1], nil
+			}
+			return s, res, nil
+		}}.argn(3).types(TypeNULL, TypeNULL, TypeDict),
+		PrimOp{"insert", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			return s, args[2].(*Dict).Insert(args[0], args[1]), nil
+		}}.argn(3).types(TypeNULL, TypeNULL, TypeDict),
+		PrimOp{"delete", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			return s, args[1].(*Dict).Delete(args[0]), nil
+		}}.argn(2).types(TypeNULL, TypeDict),
+		PrimOp{"member?", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			_, ok := args[1].(*Dict).Get(args[0])
+			return s, types.NewBool(ok), nil
+		}}.argn(2).types(TypeNULL, TypeDict),
+		PrimOp{"dict-size", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			return s, types.NewNum(int64(args[0].(*Dict).Len())), nil
+		}}.argn(1).types(TypeDict),
+		PrimOp{"keys", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			var res []Value
+			args[0].(*Dict).Iterate(func(k, _ Value) bool {
+				res = append(res, k)
+				return false
+			})
+			return s, types.NewVector(res...), nil
+		}}.argn(1).types(TypeDict),
+		PrimOp{"values", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			var res []Value
+			args[0].(*Dict).Iterate(func(_, v Value) bool {
+				res = append(res, v)
+				return false
+			})
+			return s, types.NewVector(res...), nil
+		}}.argn(1).types(TypeDict),
+		PrimOp{"map-values", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			return mapDict(s, args[1].(*Dict), func(s *Bindings, k, v Value) (*Bindings, Value, Value, error) {
+				s, v, err := callFn(s, args[0], []Value{v})
+				return s, k, v, err
+			})
+		}}.argn(2).types(TypeNULL, TypeDict),
+		PrimOp{"map-keys", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			return mapDict(s, args[1].(*Dict), func(s *Bindings, k, v Value) (*Bindings, Value, Value, error) {
+				s, k, err := callFn(s, args[0], []Value{k})
+				return s, k, v, err
+			})
+		}}.argn(2).types(TypeNULL, TypeDict),
+		PrimOp{"merge", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			res := types.NewDict()
+			for _, arg := range args {
+				arg.(*Dict).Iterate(func(k, v Value) bool {
+					res.Set(k, v)
+					return false
+				})
+			}
+			return s, res, nil
+		}}.alltypes(TypeDict),
+		PrimOp{"dict-from-seq", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			seq, ok := args[0].(types.Sequence)
+			if !ok || args[0].Type() == TypeString {
+				return nil, nil, &TypeError{"dict-from-seq", TypeList, args[0].Type()}
+			}
+			res := types.NewDict()
+			var err error
+			seq.Iterate(func(_ int, pair Value) bool {
+				kv, ok := pair.(types.Sequence)
+				if !ok || pair.Type() == TypeString || kv.Length() != 2 {
+					err = &OtherError{"dict-from-seq", "expected a [key value] pair but " + pair.String()}
+					return true
+				}
+				res.Set(kv.Index(0), kv.Index(1))
+				return false
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+			return s, res, nil
+		}}.argn(1),
+	}
+}
+
+// mapDict builds a new dict from the entries of d as transformed by f.
+// Later entries win if f maps two keys to the same one.
+func mapDict(s *Bindings, d *Dict, f func(*Bindings, Value, Value) (*Bindings, Value, Value, error)) (*Bindings, Value, error) {
+	res := types.NewDict()
+	var err error
+	d.Iterate(func(k, v Value) bool {
+		s, k, v, err = f(s, k, v)
+		if err != nil {
+			return true
+		}
+		res.Set(k, v)
+		return false
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, res, nil
+}
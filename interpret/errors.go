@@ -3,55 +3,389 @@ package radicle
 import (
 	"errors"
 	"fmt"
+
+	"github.com/mossid/dr-alice/parse"
+	"github.com/mossid/dr-alice/types"
 )
 
-func newError(ty string, fn string, format string, args ...interface{}) error {
+// ErrOutOfGas matches the errors returned when evaluation runs out of
+// gas with errors.Is.
+var ErrOutOfGas = errors.New("OutOfGas")
+
+// Error is an error raised by evaluation that catch can intercept. Kind
+// is the label catch matches it by and ToRadicle its thrown value.
+type Error interface {
+	error
+	Kind() Ident
+	ToRadicle() Value
+}
+
+func format(ty string, fn string, format string, args ...interface{}) string {
 	if fn != "" {
 		fn = "(" + fn + ")"
 	}
-	return errors.New(ty + fn + ": " + fmt.Sprintf(format, args...))
+	return ty + fn + ": " + fmt.Sprintf(format, args...)
+}
+
+// errorDict represents err as a dict of its :label, :message and fields,
+// keyed by keywords.
+func errorDict(err Error, fields map[string]Value) Value {
+	res := types.NewDict(
+		types.NewKeyword("label"), types.NewAtom(err.Kind()),
+		types.NewKeyword("message"), types.NewString(err.Error()),
+	)
+	for name, v := range fields {
+		res.Set(types.NewKeyword(name), v)
+	}
+	return res
+}
+
+type SpecialFormError struct {
+	Fn   string
+	Desc string
+}
+
+func (err *SpecialFormError) Error() string {
+	return format("SpecialForm", err.Fn, err.Desc)
+}
+
+func (err *SpecialFormError) Kind() Ident { return "SpecialForm" }
+
+func (err *SpecialFormError) ToRadicle() Value {
+	return errorDict(err, map[string]Value{"fn": types.NewString(err.Fn)})
+}
+
+type WrongNumberArgsError struct {
+	Fn       string
+	Expected int
+	Actual   int
+}
+
+func (err *WrongNumberArgsError) Error() string {
+	return format("WrongNumberArgs", err.Fn, "expected %d but %d", err.Expected, err.Actual)
 }
 
-func SpecialFormError(fn string, desc string) error {
-	return newError("SpecialForm", fn, desc)
+func (err *WrongNumberArgsError) Kind() Ident { return "WrongNumberArgs" }
+
+func (err *WrongNumberArgsError) ToRadicle() Value {
+	return errorDict(err, map[string]Value{
+		"fn":       types.NewString(err.Fn),
+		"expected": types.NewNum(int64(err.Expected)),
+		"actual":   types.NewNum(int64(err.Actual)),
+	})
 }
 
-func WrongNumberArgsError(fn string, exp int, act int) error {
-	return newError("WrongNumberArgs", fn, "expected %d but %d", exp, act)
+type TypeError struct {
+	Fn       string
+	Expected ValueType
+	Actual   ValueType
 }
 
-func TypeError(fn string, exp ValueType, act ValueType) error {
-	return newError("TypeError", fn, "expected %v but %v", exp, act)
+func (err *TypeError) Error() string {
+	return format("TypeError", err.Fn, "expected %v but %v", err.Expected, err.Actual)
 }
 
-func PatternMatchError(fn string, desc string) error {
-	return newError("PatternMatch", fn, desc)
+func (err *TypeError) Kind() Ident { return "TypeError" }
+
+func (err *TypeError) ToRadicle() Value {
+	return errorDict(err, map[string]Value{
+		"fn":       types.NewString(err.Fn),
+		"expected": types.NewKeyword(err.Expected.String()),
+		"actual":   types.NewKeyword(err.Actual.String()),
+	})
 }
 
-func NonFunctionCalledError(fun Value) error {
-	return newError("NonFunctionCalled", "", "%+v", fun)
+type PatternMatchError struct {
+	Fn   string
+	Desc string
 }
 
-func UnknownIdentifierError(id Ident) error {
-	return newError("UnknownIdentifier", "", id)
+func (err *PatternMatchError) Error() string {
+	return format("PatternMatch", err.Fn, err.Desc)
 }
 
-func ImpossibleError(fn string, desc string) error {
-	return newError("Impossible", fn, desc)
+func (err *PatternMatchError) Kind() Ident { return "PatternMatch" }
+
+func (err *PatternMatchError) ToRadicle() Value {
+	return errorDict(err, map[string]Value{"fn": types.NewString(err.Fn)})
 }
 
-func OtherError(fn string, desc string) error {
-	return newError("Other", fn, desc)
+type NonFunctionCalledError struct {
+	Value Value
+}
+
+func (err *NonFunctionCalledError) Error() string {
+	return format("NonFunctionCalled", "", "%+v", err.Value)
+}
+
+func (err *NonFunctionCalledError) Kind() Ident { return "NonFunctionCalled" }
+
+func (err *NonFunctionCalledError) ToRadicle() Value {
+	return errorDict(err, map[string]Value{"value": err.Value})
 }
 
-func ThrownError(label string, v Value) error {
-	return newError(label, v.String(), "") // TODO
+type UnknownIdentifierError struct {
+	Ident Ident
+}
+
+func (err *UnknownIdentifierError) Error() string {
+	return format("UnknownIdentifier", "", err.Ident)
+}
+
+func (err *UnknownIdentifierError) Kind() Ident { return "UnknownIdentifier" }
+
+func (err *UnknownIdentifierError) ToRadicle() Value {
+	return errorDict(err, map[string]Value{"ident": types.NewAtom(err.Ident)})
+}
+
+type DivisionByZeroError struct {
+	Fn string
+}
+
+func (err *DivisionByZeroError) Error() string {
+	return format("DivisionByZero", err.Fn, "divisor is zero")
+}
+
+func (err *DivisionByZeroError) Kind() Ident { return "DivisionByZero" }
+
+func (err *DivisionByZeroError) ToRadicle() Value {
+	return errorDict(err, map[string]Value{"fn": types.NewString(err.Fn)})
+}
+
+type NonIntegerError struct {
+	Fn    string
+	Value Value
+}
+
+func (err *NonIntegerError) Error() string {
+	return format("NonInteger", err.Fn, "expected an integer but %s", err.Value)
+}
+
+func (err *NonIntegerError) Kind() Ident { return "NonInteger" }
+
+func (err *NonIntegerError) ToRadicle() Value {
+	return errorDict(err, map[string]Value{"fn": types.NewString(err.Fn), "value": err.Value})
+}
+
+type OverflowError struct {
+	Fn    string
+	Value Value
+}
+
+func (err *OverflowError) Error() string {
+	return format("Overflow", err.Fn, "%s does not fit in a machine integer", err.Value)
+}
+
+func (err *OverflowError) Kind() Ident { return "Overflow" }
+
+func (err *OverflowError) ToRadicle() Value {
+	return errorDict(err, map[string]Value{"fn": types.NewString(err.Fn), "value": err.Value})
+}
+
+type OutOfRangeError struct {
+	Fn     string
+	Index  int
+	Length int
+}
+
+func (err *OutOfRangeError) Error() string {
+	return format("OutOfRange", err.Fn, "index %d out of range for length %d", err.Index, err.Length)
+}
+
+func (err *OutOfRangeError) Kind() Ident { return "OutOfRange" }
+
+func (err *OutOfRangeError) ToRadicle() Value {
+	return errorDict(err, map[string]Value{
+		"fn":     types.NewString(err.Fn),
+		"index":  types.NewNum(int64(err.Index)),
+		"length": types.NewNum(int64(err.Length)),
+	})
+}
+
+type ParseError struct {
+	Fn  string
+	Err error
+}
+
+func (err *ParseError) Error() string {
+	return format("Parse", err.Fn, "%s", err.Err)
+}
+
+func (err *ParseError) Unwrap() error { return err.Err }
+
+func (err *ParseError) Kind() Ident { return "Parse" }
+
+func (err *ParseError) ToRadicle() Value {
+	return errorDict(err, map[string]Value{"fn": types.NewString(err.Fn)})
+}
+
+type JSONError struct {
+	Fn  string
+	Err error
+}
+
+func (err *JSONError) Error() string {
+	return format("JSON", err.Fn, "%s", err.Err)
+}
+
+func (err *JSONError) Unwrap() error { return err.Err }
+
+func (err *JSONError) Kind() Ident { return "JSON" }
+
+func (err *JSONError) ToRadicle() Value {
+	return errorDict(err, map[string]Value{"fn": types.NewString(err.Fn)})
+}
+
+// OutOfGasError is returned when evaluation runs out of gas. It has no
+// Kind, so it is not an Error and catch, even with 'any, lets it through.
+type OutOfGasError struct {
+	Limit uint64
+}
+
+func (err *OutOfGasError) Error() string {
+	return fmt.Sprintf("%s: used all %d gas", ErrOutOfGas, err.Limit)
+}
+
+func (err *OutOfGasError) Is(target error) bool {
+	return target == ErrOutOfGas
+}
+
+// CancelledError wraps the error of a done context, so that errors.Is
+// matches context.Canceled or context.DeadlineExceeded. Like
+// OutOfGasError, it has no Kind and cannot be caught.
+type CancelledError struct {
+	Err error
+}
+
+func (err *CancelledError) Error() string {
+	return "Cancelled: " + err.Err.Error()
+}
+
+func (err *CancelledError) Unwrap() error { return err.Err }
+
+type ImpossibleError struct {
+	Fn   string
+	Desc string
+}
+
+func (err *ImpossibleError) Error() string {
+	return format("Impossible", err.Fn, err.Desc)
+}
+
+func (err *ImpossibleError) Kind() Ident { return "Impossible" }
+
+func (err *ImpossibleError) ToRadicle() Value {
+	return errorDict(err, map[string]Value{"fn": types.NewString(err.Fn)})
+}
+
+type OtherError struct {
+	Fn   string
+	Desc string
+}
+
+func (err *OtherError) Error() string {
+	return format("Other", err.Fn, err.Desc)
+}
+
+func (err *OtherError) Kind() Ident { return "Other" }
+
+func (err *OtherError) ToRadicle() Value {
+	return errorDict(err, map[string]Value{"fn": types.NewString(err.Fn)})
+}
+
+// ThrownError is raised by throw. It keeps the thrown value for catch.
+type ThrownError struct {
+	Label Ident
+	Value Value
+}
+
+func (err *ThrownError) Error() string {
+	return err.Label + ": " + err.Value.String()
+}
+
+func (err *ThrownError) Kind() Ident { return err.Label }
+
+// ToRadicle returns the thrown value itself.
+func (err *ThrownError) ToRadicle() Value { return err.Value }
+
+// ModuleError is raised by an invalid module declaration.
+type ModuleError struct {
+	Desc   string
+	Detail string
+}
+
+func (err *ModuleError) Error() string {
+	return format("Module", err.Desc, err.Detail)
+}
+
+func (err *ModuleError) Kind() Ident { return "Module" }
+
+func (err *ModuleError) ToRadicle() Value {
+	return errorDict(err, map[string]Value{"detail": types.NewString(err.Detail)})
 }
 
 func InvalidDeclaration(desc string, v Value) error {
-	return newError("Module", desc, v.String())
+	return &ModuleError{desc, v.String()}
 }
 
 func UndefinedExports(e string) error {
-	return newError("Module", "undefined exports", e)
+	return &ModuleError{"undefined exports", e}
+}
+
+// thrown returns the label and value catch sees for err. Running out of
+// gas or time, or exceeding a limit, cannot be caught, so that a program
+// cannot escape its budget.
+func thrown(err error) (Ident, Value, bool) {
+	var rerr Error
+	if errors.As(err, &rerr) {
+		return rerr.Kind(), rerr.ToRadicle(), true
+	}
+	return "", nil, false
+}
+
+// Resource names a resource bounded by Limits.
+type Resource string
+
+const (
+	ResourceDepth Resource = "depth"
+	ResourceRefs  Resource = "refs"
+	ResourceSize  Resource = "size"
+)
+
+// ResourceLimitError is returned when evaluation exceeds one of its Limits.
+// Like OutOfGasError, it has no Kind and cannot be caught.
+type ResourceLimitError struct {
+	Resource Resource
+	Limit    int
+}
+
+func (err *ResourceLimitError) Error() string {
+	return fmt.Sprintf("ResourceLimit(%s): exceeded limit of %d", err.Resource, err.Limit)
+}
+
+// SourceError is an evaluation error located at the expression that raised it.
+type SourceError struct {
+	Span parse.Span
+	Err  error
+}
+
+func (err *SourceError) Error() string {
+	return err.Span.String() + ": " + err.Err.Error()
+}
+
+func (err *SourceError) Unwrap() error {
+	return err.Err
+}
+
+// locate attaches the span of v to err, unless err is already located
+// by a more specific expression.
+func locate(s *Bindings, v Value, err error) error {
+	if _, ok := err.(*SourceError); ok {
+		return err
+	}
+	sp, ok := s.Spans.Span(v)
+	if !ok {
+		return err
+	}
+	return &SourceError{sp, err}
 }
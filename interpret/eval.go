@@ -1,6 +1,9 @@
 package radicle
 
 import (
+	"context"
+
+	"github.com/mossid/dr-alice/parse"
 	"github.com/mossid/dr-alice/types"
 )
 
@@ -10,6 +13,19 @@ type Bindings struct {
 	PrimFn func(Ident) PrimOpRun
 	Refs   *Intmap
 	//	Mem map[Ref]Value
+
+	// Spans, if not nil, locates the source of evaluated expressions
+	// in errors.
+	Spans parse.Spans
+	// Gas, if not nil, limits the work evaluation may do.
+	Gas *Gas
+	// Limits, if not nil, bounds the depth, refs and sizes of evaluation.
+	Limits *Limits
+
+	// ctx, if not nil, stops evaluation once it is done, and undo, if not
+	// nil, records ref writes to undo if it fails. See BaseEvalContext.
+	ctx  context.Context
+	undo *refLog
 }
 
 func NewBindings(env Env, fn func(Ident) PrimOpRun, refs *Intmap) *Bindings {
@@ -21,19 +37,98 @@ func NewBindings(env Env, fn func(Ident) PrimOpRun, refs *Intmap) *Bindings {
 }
 
 func EmptyBindings() *Bindings {
-	return NewBindings(types.NewListEnv(), MapPrimOpRuns(PurePrimFns()), types.NewIntmap())
+	return NewBindings(types.NewEnv(), MapPrimOpRuns(PurePrimFns()), types.NewIntmap())
 }
 
 func (s *Bindings) ModifyEnv(f func(Env) Env) *Bindings {
-	return NewBindings(f(s.Env), s.PrimFn, s.Refs)
+	return s.SetEnv(f(s.Env))
 }
 
 func (s *Bindings) SetEnv(env Env) *Bindings {
-	return NewBindings(env, s.PrimFn, s.Refs)
+	res := *s
+	res.Env = env
+	return &res
 }
 
 func (s *Bindings) SetRefs(refs *Intmap) *Bindings {
-	return NewBindings(s.Env, s.PrimFn, refs)
+	res := *s
+	res.Refs = refs
+	return &res
+}
+
+func (s *Bindings) SetSpans(spans parse.Spans) *Bindings {
+	res := *s
+	res.Spans = spans
+	return &res
+}
+
+func (s *Bindings) SetGas(gas *Gas) *Bindings {
+	res := *s
+	res.Gas = gas
+	return &res
+}
+
+func (s *Bindings) SetLimits(limits *Limits) *Bindings {
+	res := *s
+	res.Limits = limits
+	return &res
+}
+
+// interrupted returns an error if the context of s is done.
+func (s *Bindings) interrupted() error {
+	if s.ctx == nil {
+		return nil
+	}
+	select {
+	case <-s.ctx.Done():
+		return &CancelledError{s.ctx.Err()}
+	default:
+		return nil
+	}
+}
+
+// newRef stores v in a new ref and returns its index.
+func (s *Bindings) newRef(v Value) uint64 {
+	ix := s.Refs.Insert(v)
+	s.undo.record(refWrite{s.Refs, ix, nil, false})
+	return ix
+}
+
+// writeRef stores v in the ref at ix.
+func (s *Bindings) writeRef(ix uint64, v Value) {
+	old, existed := s.Refs.Get(ix)
+	s.undo.record(refWrite{s.Refs, ix, old, existed})
+	s.Refs.Set(ix, v)
+}
+
+// refLog is a log of ref writes, oldest first.
+type refLog []refWrite
+
+// refWrite is a write to the ref at ix in refs, which held old before if
+// it existed. Refs may hold nil.
+type refWrite struct {
+	refs    *Intmap
+	ix      uint64
+	old     Value
+	existed bool
+}
+
+func (l *refLog) record(w refWrite) {
+	if l != nil {
+		*l = append(*l, w)
+	}
+}
+
+// rollback undoes the writes in l, newest first.
+func (l *refLog) rollback() {
+	for i := len(*l) - 1; i >= 0; i-- {
+		w := (*l)[i]
+		if !w.existed {
+			w.refs.Remove(w.ix)
+		} else {
+			w.refs.Set(w.ix, w.old)
+		}
+	}
 }
 
 func (s *Bindings) ToRadicle() Value {
@@ -49,7 +144,76 @@ type ModuleMeta struct {
 	Doc     string
 }
 
+// tailCall is an expression left to evaluate in tail position.
+type tailCall struct {
+	s *Bindings
+	v Value
+	// ret, if not nil, are the bindings to return once v is evaluated,
+	// i.e. those of the caller of the lambda v is the body of.
+	ret *Bindings
+}
+
+// BaseEval evaluates v. Expressions in tail position, namely the branches
+// of if and cond, the last expression of do and the last body of a lambda,
+// are evaluated by this loop instead of recursively, so tail calls run in
+// constant stack space.
 func BaseEval(s *Bindings, v Value) (*Bindings, Value, error) {
+	limits := s.Limits
+	if err := limits.enter(); err != nil {
+		return nil, nil, locate(s, v, err)
+	}
+	defer limits.leave()
+
+	var ret *Bindings
+	for {
+		if err := s.Gas.step(v); err != nil {
+			return nil, nil, locate(s, v, err)
+		}
+		if _, ok := v.(*List); ok {
+			if err := s.interrupted(); err != nil {
+				return nil, nil, locate(s, v, err)
+			}
+		}
+		s0, res, tail, err := baseEval(s, v)
+		if err != nil {
+			return nil, nil, locate(s, v, err)
+		}
+		if tail == nil {
+			if ret != nil {
+				return ret, res, nil
+			}
+			return s0, res, nil
+		}
+		if ret == nil {
+			ret = tail.ret
+		}
+		s, v = tail.s, tail.v
+	}
+}
+
+// BaseEvalContext evaluates v like BaseEval, but stops with an error
+// wrapping ctx.Err() once ctx is done. The context is checked before
+// every call, including every iteration of a loop. If evaluation fails,
+// for cancellation or any other reason, every ref it wrote is restored,
+// including the refs of states evaluated with base-eval.
+func BaseEvalContext(ctx context.Context, s *Bindings, v Value) (*Bindings, Value, error) {
+	var undo refLog
+	res := *s
+	res.ctx, res.undo = ctx, &undo
+	s0, v0, err := BaseEval(&res, v)
+	if err != nil {
+		undo.rollback()
+		return nil, nil, err
+	}
+	for _, w := range undo {
+		s.undo.record(w)
+	}
+	res = *s0
+	res.ctx, res.undo = s.ctx, s.undo
+	return &res, v0, nil
+}
+
+func baseEval(s *Bindings, v Value) (*Bindings, Value, *tailCall, error) {
 	switch v := v.(type) {
 	case *Atom:
 		// BEGIN
@@ -57,106 +221,183 @@ func BaseEval(s *Bindings, v Value) (*Bindings, Value, error) {
 		// I couldn't found how does the primfns are generated from atoms
 		primfn := s.PrimFn(v.Ident())
 		if primfn != nil {
-			return s, types.NewPrimFn(v), nil
+			return s, types.NewPrimFn(v), nil, nil
 		}
 		// END
 
 		res, ok := s.Env.Get(v.Ident())
 		if ok {
-			return s, res, nil
+			return s, res, nil, nil
 		}
-		return s, v, UnknownIdentifierError(v.Ident())
+		return s, v, nil, &UnknownIdentifierError{v.Ident()}
 	case *List:
 		l := v.List()
 		if len(l) < 1 {
-			return nil, nil, WrongNumberArgsError("application", 2, len(l))
+			return nil, nil, nil, &WrongNumberArgsError{"application", 2, len(l)}
 		}
 		return dollarDollar(s, l[0], l[1:])
 	case *Vector:
 		xs := v.Vector()
-		res := Vector(make([]Value, len(xs)))
-		var err error
 		for i, x := range xs {
-			s, res[i], err = BaseEval(s, x)
+			var err error
+			s, xs[i], err = BaseEval(s, x)
 			if err != nil {
-				return nil, nil, err
+				return nil, nil, nil, err
 			}
 		}
-		return s, &res, nil
+		res := types.NewVector(xs...)
+		if err := s.alloc(xs, res); err != nil {
+			return nil, nil, nil, err
+		}
+		return s, res, nil, nil
 	case *Dict:
-		res := Dict(make(map[Value]Value))
-		var k0, v0 Value
+		res := types.NewDict()
+		var parts []Value
 		var err error
-		for k, v := range *v {
+		v.Iterate(func(k, v Value) bool {
+			var k0, v0 Value
 			s, k0, err = BaseEval(s, k)
 			if err != nil {
-				return nil, nil, err
+				return true
 			}
 			s, v0, err = BaseEval(s, v)
 			if err != nil {
-				return nil, nil, err
+				return true
 			}
-			res[k0] = v0
+			res.Set(k0, v0)
+			parts = append(parts, k0, v0)
+			return false
+		})
+		if err != nil {
+			return nil, nil, nil, err
 		}
-		return s, &res, nil
+		if err := s.alloc(parts, res); err != nil {
+			return nil, nil, nil, err
+		}
+		return s, res, nil, nil
 	default:
-		return s, v, nil
+		return s, v, nil, nil
 	}
 }
 
-func dollarDollar(s *Bindings, f Value, args []Value) (*Bindings, Value, error) {
+func dollarDollar(s *Bindings, f Value, args []Value) (*Bindings, Value, *tailCall, error) {
 	fatom, ok := f.(*Atom)
 	if ok {
+		if tf := MapTailForm(fatom.Ident()); tf != nil {
+			s0, expr, err := tf(s, args)
+			if err != nil || expr == nil {
+				return s0, nil, nil, err
+			}
+			return nil, nil, &tailCall{s0, expr, nil}, nil
+		}
 		f0 := MapSpecialForm(fatom.Ident())
 		if f0 != nil {
-			return f0(s, args)
+			s0, res, err := f0(s, args)
+			return s0, res, nil, err
 		}
 	}
 
 	s0, f0, err := BaseEval(s, f)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	args0 := make([]Value, len(args))
 	for i, arg := range args {
 		s0, args0[i], err = BaseEval(s0, arg)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
+		}
+	}
+
+	if l := lambda(f0); l != nil {
+		s1, last, err := enter(s0, l, args0)
+		if err != nil || last == nil {
+			return s0, nil, nil, err
 		}
+		return nil, nil, &tailCall{s1, last, s0}, nil
 	}
-	return callFn(s0, f0, args0)
+	s1, res, err := callFn(s0, f0, args0)
+	return s1, res, nil, err
 }
 
 func callFn(s *Bindings, v Value, args []Value) (*Bindings, Value, error) {
+	if err := s.interrupted(); err != nil {
+		return nil, nil, err
+	}
+	if l := lambda(v); l != nil {
+		s0, last, err := enter(s, l, args)
+		if err != nil || last == nil {
+			return s, nil, err
+		}
+		_, res, err := BaseEval(s0, last)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, res, nil
+	}
+
 	switch v := v.(type) {
-	case *Lambda:
-		if len(v.Args) != len(args) {
-			return nil, nil, WrongNumberArgsError("lambda", len(v.Args), len(args))
+	case *PrimFn:
+		if err := s.Gas.primOp(v.Ident(), args); err != nil {
+			return nil, nil, err
 		}
-		env := v.Env
-		for i, name := range v.Args {
-			env = env.Set(name, args[i])
+		s0, res, err := s.PrimFn(v.Ident())(s, args)
+		if err != nil {
+			return nil, nil, err
 		}
-		s0 := s.SetEnv(env)
-		var res Value
-		var err error
-		for _, expr := range v.Bodies {
-			s0, res, err = BaseEval(s0, expr)
-			if err != nil {
-				return nil, nil, err
-			}
+		if err := s.alloc(args, res); err != nil {
+			return nil, nil, err
 		}
-		return s, res, err
+		return s0, res, nil
+	default:
+		return nil, nil, &NonFunctionCalledError{v}
+	}
+}
+
+// alloc charges gas for res, a value built from parts, and checks its
+// size against the limits.
+func (s *Bindings) alloc(parts []Value, res Value) error {
+	if err := s.Gas.alloc(parts, res); err != nil {
+		return err
+	}
+	return s.Limits.size(res)
+}
+
+// lambda returns v as a lambda, binding itself in its env if it is
+// recursive, or nil if v is not a lambda.
+func lambda(v Value) *Lambda {
+	switch v := v.(type) {
+	case *Lambda:
+		return v
 	case *LambdaRec:
-		l := &Lambda{v.Args, v.Bodies, v.Env.Set(v.Self, v)}
-		_, res, err := callFn(nil, l, args)
-		return s, res, err
-	case *PrimFn:
-		fn := s.PrimFn(v.Ident())
-		return fn(s, args)
+		return &Lambda{v.Args, v.Bodies, v.Env.Set(v.Self, v)}
 	default:
-		return nil, nil, NonFunctionCalledError(v)
+		return nil
+	}
+}
+
+// enter binds args in the env of l and evaluates all bodies but the last,
+// which it returns with the bindings to evaluate it in.
+func enter(s *Bindings, l *Lambda, args []Value) (*Bindings, Value, error) {
+	if len(l.Args) != len(args) {
+		return nil, nil, &WrongNumberArgsError{"lambda", len(l.Args), len(args)}
+	}
+	env := l.Env
+	for i, name := range l.Args {
+		env = env.Set(name, args[i])
+	}
+	s0 := s.SetEnv(env)
+	if len(l.Bodies) == 0 {
+		return s0, nil, nil
+	}
+	for _, expr := range l.Bodies[:len(l.Bodies)-1] {
+		var err error
+		s0, _, err = BaseEval(s0, expr)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
+	return s0, l.Bodies[len(l.Bodies)-1], nil
 }
 
 /*
@@ -1,8 +1,12 @@
 package radicle
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -36,6 +40,37 @@ func parseEval(env types.Env, expr string) types.Value {
 	return v
 }
 
+// evalCase is an expression and what it evaluates to. A Result of ()
+// expects nil.
+type evalCase struct {
+	Input  string
+	Result string
+}
+
+func checkResults(t *testing.T, env types.Env, tcs []evalCase) {
+	for _, tc := range tcs {
+		v := parseEval(env, tc.Input)
+		if tc.Result == `()` {
+			require.Nil(t, v, "Not nil: %s", tc.Input)
+			continue
+		}
+		require.True(t, parse.Expr(tc.Result).Equal(v), "Not equal: %s: expected %s but %s", tc.Input, tc.Result, v)
+	}
+}
+
+// errorCase is an expression and the error it fails with.
+type errorCase struct {
+	Input string
+	Error string
+}
+
+func checkErrors(t *testing.T, env types.Env, errs []errorCase) {
+	for _, tc := range errs {
+		_, _, err := BaseEval(EmptyBindings().SetEnv(env), parse.Expr(tc.Input))
+		require.EqualError(t, err, tc.Error, "Wrong error: %s", tc.Input)
+	}
+}
+
 func TestBaseEval(t *testing.T) {
 	env := types.NewListEnv()
 
@@ -99,9 +134,10 @@ func TestBaseEval(t *testing.T) {
 		{
 			// 7
 			// Church numeric
+			// z closes over s, which was defined before it
 			env,
 			parse.Expr(church("z")),
-			parseEval(env, "(fn [f x] x)"),
+			parseEval(env, "(do (def s (fn [n] (fn [f x] (f (n f x))))) (fn [f x] x))"),
 		},
 		{
 			// 8
@@ -142,6 +178,34 @@ func TestBaseEval(t *testing.T) {
 			parse.Expr(`(do (def r (ref 3)) (write-ref r 4) (read-ref r))`),
 			parse.Expr("4"),
 		},
+		{
+			// 13
+			// Quasiquote holes and splices
+			env,
+			parse.Expr("(do (def x 1) (def xs '(2 3)) `(a ,x ,@xs [,x ,@[4 5]] {:k ,(+ x 1)} ()))"),
+			parse.Expr("(a 1 2 3 [1 4 5] {:k 2} ())"),
+		},
+		{
+			// 14
+			// Nested quasiquote only evaluates the outer holes
+			env,
+			parse.Expr("(do (def x 1) `(a `(b ,(c ,x ,@'(d)) ,,x)))"),
+			parse.Expr("(a (quasiquote (b (unquote (c 1 d)) (unquote 1))))"),
+		},
+		{
+			// 15
+			// Exact decimals
+			env,
+			parse.Expr("(+ 0.1 0.2)"),
+			parse.Expr("0.3"),
+		},
+		{
+			// 16
+			// Integers are promoted instead of overflowing
+			env,
+			parse.Expr("(+ 9223372036854775807 1/3)"),
+			parse.Expr("27670116110564327422/3"),
+		},
 	}
 
 	for i, tc := range tcs {
@@ -156,3 +220,586 @@ func TestBaseEval(t *testing.T) {
 		}
 	}
 }
+
+func TestSourceError(t *testing.T) {
+	tcs := []struct {
+		Input string
+		Error string
+	}{
+		{
+			"(do\n  (def x 1)\n  (+ x foo))",
+			"test.rad:3:8: UnknownIdentifier: foo",
+		},
+		{
+			"(do\n  (def f (fn [] bar))\n  (f))",
+			"test.rad:2:17: UnknownIdentifier: bar",
+		},
+		{
+			"(do (def x :a) (+ x 1))",
+			"test.rad:1:16: TypeError(+): expected number but keyword",
+		},
+	}
+
+	for i, tc := range tcs {
+		spans := make(parse.Spans)
+		v, err := parse.ReadSource("test.rad", tc.Input, spans)
+		require.NoError(t, err)
+		state := EmptyBindings().SetSpans(spans)
+		_, _, err = BaseEval(state, v)
+		require.EqualError(t, err, tc.Error, "Wrong error(%d)", i)
+		_, ok := err.(*SourceError)
+		require.True(t, ok, "Not located(%d)", i)
+	}
+}
+
+func TestNumPrimFns(t *testing.T) {
+	tcs := []evalCase{
+		{"(+)", "0"},
+		{"(+ 1 2 3 4)", "10"},
+		{"(*)", "1"},
+		{"(* 2 3 1/2)", "3"},
+		{"(* 4294967296 4294967296 4294967296)", "79228162514264337593543950336"},
+		{"(- 5)", "-5"},
+		{"(- 10 1 2)", "7"},
+		{"(/ 4)", "1/4"},
+		{"(/ 1 3 2)", "1/6"},
+		{"(/ 5 2)", "2.5"},
+		{"(quot 7 2)", "3"},
+		{"(quot -7 2)", "-3"},
+		{"(mod 7 2)", "1"},
+		{"(mod -7 2)", "1"},
+		{"(mod 7 -2)", "-1"},
+		{"(abs -3/4)", "0.75"},
+		{"(min 3 1/2 2)", "0.5"},
+		{"(max 3 1/2 2)", "3"},
+		{"(< 1 2 3)", "#t"},
+		{"(< 1 3 2)", "#f"},
+		{"(<= 1 1 2)", "#t"},
+		{"(> 3 2 1)", "#t"},
+		{"(>= 1 2)", "#f"},
+		{"(= 1/2 0.5 2/4)", "#t"},
+		{"(= 1 2)", "#f"},
+		{"(nth 1 [:a :b])", ":b"},
+	}
+
+	checkResults(t, types.NewListEnv(), tcs)
+
+	errs := []errorCase{
+		{"(/ 1 0)", "DivisionByZero(/): divisor is zero"},
+		{"(mod 1 0)", "DivisionByZero(mod): divisor is zero"},
+		{"(quot 1/2 1)", "NonInteger(quot): expected an integer but 0.5"},
+		{"(nth 1.5 [1 2])", "NonInteger(nth): expected an integer but 1.5"},
+		{"(nth 9223372036854775808 [1 2])", "Overflow(nth): 9223372036854775808 does not fit in a machine integer"},
+		{"(+ 1 :a)", "TypeError(+): expected number but keyword"},
+		{"(-)", "WrongNumberArgs(-): expected 1 but 0"},
+	}
+
+	checkErrors(t, types.NewEnv(), errs)
+}
+
+func TestStringPrimFns(t *testing.T) {
+	tcs := []evalCase{
+		{`(string-append)`, `""`},
+		{`(string-append "héllo" ", " "wörld")`, `"héllo, wörld"`},
+		{`(string-length "héllo")`, `5`},
+		{`(length "héllo")`, `5`},
+		{`(nth 1 "héllo")`, `"é"`},
+		{`(drop 1 "héllo")`, `"éllo"`},
+		{`(substring "héllo" 1 3)`, `"él"`},
+		{`(substring "héllo" 5 5)`, `""`},
+		{`(string-split "," "a,b,,c")`, `["a" "b" "" "c"]`},
+		{`(string-join ", " ["a" "b" "c"])`, `"a, b, c"`},
+		{`(string-join "-" '())`, `""`},
+		{`(string-replace "l" "L" "héllo")`, `"héLLo"`},
+		{`(string->list "hé")`, `("h" "é")`},
+		{`(list->string (string->list "hé"))`, `"hé"`},
+		{`(list->string ["a" "bc"])`, `"abc"`},
+		// bytes that are not valid UTF-8 are characters kept as is
+		{`(nth 1 "a\x{ff}b")`, `"\x{ff}"`},
+		{`(string-length "é\x{ff}")`, `2`},
+		{`(do (def s "é\x{ff}") (eq? s (substring s 0 (string-length s))))`, `#t`},
+		{`(list->string (string->list "é\x{ff}b"))`, `"é\x{ff}b"`},
+		{`(string-upcase "héllo")`, `"HÉLLO"`},
+		{`(string-downcase "HÉLLO")`, `"héllo"`},
+		{`(show '(a "b\n" 1/2))`, `"(a \"b\\n\" 0.5)"`},
+		{`(read "(a \"b\\n\" 1/2)")`, `(a "b\n" 0.5)`},
+		{`(read (show "a\"b"))`, `"a\"b"`},
+	}
+
+	checkResults(t, types.NewListEnv(), tcs)
+
+	errs := []errorCase{
+		{`(substring "abc" 2 4)`, "OutOfRange(substring): index 4 out of range for length 3"},
+		{`(substring "abc" 2 1)`, "OutOfRange(substring): index 1 out of range for length 3"},
+		{`(string-join "," [1 2])`, "TypeError(string-join): expected string but number"},
+		{`(read "(a")`, `Parse(read): 1:3: expected value or ")" but found end of input`},
+		{`(substring "abc")`, "WrongNumberArgs(substring): expected 3 but 1"},
+		{`(string-replace "a" "b")`, "WrongNumberArgs(string-replace): expected 3 but 2"},
+		{`(string-split ",")`, "WrongNumberArgs(string-split): expected 2 but 1"},
+		{`(string-join)`, "WrongNumberArgs(string-join): expected 2 but 0"},
+		{`(string-length)`, "WrongNumberArgs(string-length): expected 1 but 0"},
+		{`(string-upcase)`, "WrongNumberArgs(string-upcase): expected 1 but 0"},
+	}
+
+	checkErrors(t, types.NewEnv(), errs)
+}
+
+func TestDictPrimFns(t *testing.T) {
+	env := SetEnv(types.NewListEnv(), "a", ":a", "b", ":b", "c", ":c")
+	tcs := []evalCase{
+		{`(lookup a {a 1})`, `1`},
+		{`(lookup-default c 0 {a 1})`, `0`},
+		{`(lookup-default a 0 {a 1})`, `1`},
+		{`(insert b 2 {a 1})`, `{:a 1 :b 2}`},
+		{`(insert a 2 {a 1})`, `{:a 2}`},
+		{`(delete a {a 1 b 2})`, `{:b 2}`},
+		{`(delete c {a 1})`, `{:a 1}`},
+		{`(member? a {a 1})`, `#t`},
+		{`(member? b {a 1})`, `#f`},
+		{`(dict-size {})`, `0`},
+		{`(dict-size {a 1 b 2})`, `2`},
+		{`(keys {a 1})`, `[:a]`},
+		{`(values {a 1})`, `[1]`},
+		{`(map-values (fn [x] (+ x 1)) {a 1 b 2})`, `{:a 2 :b 3}`},
+		{`(map-keys (fn [k] [k]) {a 1})`, `{[:a] 1}`},
+		{`(merge)`, `{}`},
+		{`(merge {a 1 b 2} {b 3} {c 4})`, `{:a 1 :b 3 :c 4}`},
+		{`(dict-from-seq [[a 1] (list b 2)])`, `{:a 1 :b 2}`},
+		{`(do (def d {a 1}) (insert b 2 d) (delete a d) d)`, `{:a 1}`},
+		{`(lookup :a {:a 1})`, `1`},
+		{`(dict-size {:a 1 :a 2})`, `1`},
+		{`(lookup [1 "x" {:k '(y)}] {[1 "x" {:k '(y)}] :ok})`, `:ok`},
+		{`(lookup 0.5 {1/2 :half})`, `:half`},
+		{`(delete :a (insert :a 1 {}))`, `{}`},
+		{`(keys {:c 1 :a 2 :b 3})`, `[:a :b :c]`},
+		{`(keys {10 :x 2 :x 1/2 :x "b" :x "a" :x})`, `["a" "b" 0.5 2 10]`},
+		{`(eq? {:a [1 2] :b "x"} {:b "x" :a [1 2]})`, `#t`},
+		{`(eq? {:a 1} {:a 2})`, `#f`},
+		{`(eq? {:a 1} {:b 1})`, `#f`},
+		{`(do (def d (insert :a 1 {})) (def e (insert :b 2 d)) [d e (delete :a e) d])`, `[{:a 1} {:a 1 :b 2} {:b 2} {:a 1}]`},
+	}
+
+	checkResults(t, env, tcs)
+
+	errs := []errorCase{
+		{`(lookup c {a 1})`, "Other(lookup): key did not exist: :c"},
+		{`(dict-from-seq [[a 1 2]])`, "Other(dict-from-seq): expected a [key value] pair but [:a 1 2]"},
+		{`(merge {} [])`, "TypeError(merge): expected dict but vector"},
+		{`(insert 1 2)`, "WrongNumberArgs(insert): expected 3 but 2"},
+		{`(lookup :a)`, "WrongNumberArgs(lookup): expected 2 but 1"},
+		{`(lookup-default :a 0)`, "WrongNumberArgs(lookup-default): expected 3 but 2"},
+		{`(delete)`, "WrongNumberArgs(delete): expected 2 but 0"},
+		{`(member? :a)`, "WrongNumberArgs(member?): expected 2 but 1"},
+		{`(map-values (fn [x] x))`, "WrongNumberArgs(map-values): expected 2 but 1"},
+		{`(map-keys)`, "WrongNumberArgs(map-keys): expected 2 but 0"},
+		{`(dict-size)`, "WrongNumberArgs(dict-size): expected 1 but 0"},
+	}
+
+	checkErrors(t, env, errs)
+}
+
+func TestPrinting(t *testing.T) {
+	env := SetEnv(types.NewListEnv(), "b", "1", "a", `"x"`, "b", "2")
+	require.Equal(t, `{a => "x", b => 2}`, env.String())
+	require.Equal(t, env.String(), env.CloneMutable().String())
+
+	refs := types.NewIntmap()
+	refs.Insert(parse.Expr(":x"))
+	refs.Insert(parse.Expr("{:b 2 :a 1}"))
+	state := types.NewState(env, refs)
+	require.Equal(t, `(state {a => "x", b => 2} {#0 :x #1 {:a 1 :b 2}})`, state.String())
+
+	v := parseEval(env, `(fn [x y] (+ x y b))`)
+	require.Equal(t, `(fn [x y] (+ x y b))`, v.String())
+}
+
+func TestHash(t *testing.T) {
+	tcs := []evalCase{
+		{`(string-length (hash 1))`, `64`},
+		{`(eq? (hash {:a 1 :b 2}) (hash {:b 2 :a 1}))`, `#t`},
+		{`(eq? (hash 1) (hash 2))`, `#f`},
+		{`(eq? (hash 1) (hash "1"))`, `#f`},
+		{`(eq? (fn [y] (+ y 1)) (fn [y] (+ y 1)))`, `#t`},
+		{`(eq? (fn [y] (+ y 1)) (fn [z] (+ z 1)))`, `#f`},
+		{`(do (def adder (fn [x] (fn [y] (+ x y)))) (eq? (adder 1) (adder 1)))`, `#t`},
+		{`(do (def adder (fn [x] (fn [y] (+ x y)))) (eq? (adder 1) (adder 2)))`, `#f`},
+		{`(do (def adder (fn [x] (fn [y] (+ x y)))) (eq? (hash (adder 1)) (hash (adder 1))))`, `#t`},
+	}
+
+	checkResults(t, types.NewListEnv(), tcs)
+
+	var defs strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&defs, "(def f%d (fn [x] x))", i)
+	}
+	checkResults(t, types.NewEnv(), []evalCase{
+		{`(do ` + defs.String() + ` (eq? f999 f999))`, `#t`},
+		{`(do ` + defs.String() + ` (eq? f998 f999))`, `#f`},
+	})
+}
+
+func TestJSONPrimFns(t *testing.T) {
+	tcs := []evalCase{
+		{`(to-json {"a" [1 :k]})`, `"{\"a\":[1,{\"$keyword\":\"k\"}]}"`},
+		{`(from-json "{\"a\": [1, {\"$keyword\": \"k\"}]}")`, `{"a" [1 :k]}`},
+		{`(from-json (to-json {:a '(x 1/3)}))`, `{:a (x 1/3)}`},
+	}
+
+	checkResults(t, types.NewListEnv(), tcs)
+
+	errs := []errorCase{
+		{`(to-json [+])`, "JSON(to-json): cannot encode + as JSON"},
+		{`(from-json "[1,")`, "JSON(from-json): unexpected EOF"},
+		{`(from-json 1)`, "TypeError(from-json): expected string but number"},
+	}
+
+	checkErrors(t, types.NewEnv(), errs)
+}
+
+func TestVectorPrimFns(t *testing.T) {
+	tcs := []evalCase{
+		{`(add-right [1 2] 3)`, `[1 2 3]`},
+		{`(do (def v [1 2 3]) [(add-right (rest v) 4) (add-right (rest v) 5) v])`, `[[2 3 4] [2 3 5] [1 2 3]]`},
+		{`(do (def v [1 2 3]) [(add-right (take 2 v) 4) (add-right (take 1 v) 5) v])`, `[[1 2 4] [1 5] [1 2 3]]`},
+		{`(do (def v [1 2 3]) [(cons 0 v) (drop 2 v) (first (rest v)) v])`, `[[0 1 2 3] [3] 2 [1 2 3]]`},
+		{`(length (add-right (drop 1 [1 2 3]) 4))`, `3`},
+	}
+
+	checkResults(t, types.NewListEnv(), tcs)
+}
+
+func TestSequenceBounds(t *testing.T) {
+	tcs := []evalCase{
+		{`(length (list))`, `0`},
+		{`(length (cons 0 '(1 2)))`, `3`},
+		{`(take 0 (list))`, `()`},
+		{`(drop 0 (list))`, `()`},
+		{`(take 2 '(1 2 3))`, `(1 2)`},
+		{`(drop 2 '(1 2 3))`, `(3)`},
+		{`(drop 3 '(1 2 3))`, `()`},
+		{`(take 3 '(1 2 3))`, `(1 2 3)`},
+		{`(nth 2 '(1 2 3))`, `3`},
+	}
+
+	checkResults(t, types.NewListEnv(), tcs)
+
+	errs := []errorCase{
+		{`(nth 3 '(1 2 3))`, "OutOfRange(nth): index 3 out of range for length 3"},
+		{`(nth 0 (list))`, "OutOfRange(nth): index 0 out of range for length 0"},
+		{`(nth -1 [1])`, "OutOfRange(nth): index -1 out of range for length 1"},
+		{`(nth 1 "a")`, "OutOfRange(nth): index 1 out of range for length 1"},
+		{`(take 4 '(1 2 3))`, "OutOfRange(take): index 4 out of range for length 3"},
+		{`(drop 1 (list))`, "OutOfRange(drop): index 1 out of range for length 0"},
+		{`(drop -1 [1])`, "OutOfRange(drop): index -1 out of range for length 1"},
+		{`(take 2 "a")`, "OutOfRange(take): index 2 out of range for length 1"},
+		{`(take)`, "WrongNumberArgs(take): expected 2 but 0"},
+		{`(drop 1)`, "WrongNumberArgs(drop): expected 2 but 1"},
+		{`(nth 0)`, "WrongNumberArgs(nth): expected 2 but 1"},
+		{`(base-eval)`, "WrongNumberArgs(base-eval): expected 2 but 0"},
+	}
+
+	checkErrors(t, types.NewEnv(), errs)
+}
+
+// TestPrimOpArgs calls every primop with up to three arguments of assorted
+// types and checks that none of them panics.
+func TestPrimOpArgs(t *testing.T) {
+	s := EmptyBindings()
+	vals := []Value{
+		parse.Expr(`1`), parse.Expr(`-1/2`), parse.Expr(`"ab"`), parse.Expr(`[1]`),
+		parse.Expr(`(1)`), parse.Expr(`{:a 1}`), parse.Expr(`:k`), parse.Expr(`x`),
+		types.NewPrimFn(types.NewAtom("list")), s.ToRadicle(),
+	}
+
+	var call func(op PrimOp, args []Value)
+	call = func(op PrimOp, args []Value) {
+		require.NotPanics(t, func() { op.Run(EmptyBindings(), args) }, "Panicked: %s %v", op.Name, args)
+		if len(args) == 3 {
+			return
+		}
+		for _, v := range vals {
+			call(op, append(args[:len(args):len(args)], v))
+		}
+	}
+	for _, op := range PurePrimFns() {
+		call(op, nil)
+	}
+}
+
+func BenchmarkRecursion(b *testing.B) {
+	var prelude strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&prelude, "(def f%d %d)\n", i, i)
+	}
+	expr := parse.Expr(`(do (def one 1) ` + prelude.String() + `
+		(def-rec count (fn [n] (if (eq? n 0) one (count (- n one)))))
+		(count 500))`)
+
+	for _, env := range []struct {
+		Name string
+		Env  Env
+	}{
+		{"listEnv", types.NewListEnv()},
+		{"hamtEnv", types.NewEnv()},
+	} {
+		b.Run(env.Name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, _, err := BaseEval(EmptyBindings().SetEnv(env.Env), expr)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestEnvPrimFns(t *testing.T) {
+	mod := `(def m (module {:module 'm :doc "" :exports '[x]} (def x 1) (def y 2) (def x 3)))`
+	tcs := []evalCase{
+		{`(do ` + mod + ` (eq? m m))`, `#t`},
+		{`(do ` + mod + ` (lookup :exports m))`, `[x]`},
+		{`(do ` + mod + ` (env-bindings (lookup :env m)))`, `{x 3 y 2}`},
+		{`(do ` + mod + ` (def n (module {:module 'n :doc "" :exports '[x]} (def y 2) (def x 3))) (eq? m n))`, `#f`},
+		{`(do (def ms [(module {:module 'm :doc "" :exports '[x]} (def x 1) (def y 2) (def x 3))
+			(module {:module 'm :doc "" :exports '[x]} (def y 2) (def x 3))]) (eq? (first ms) (nth 1 ms)))`, `#t`},
+		{`(do ` + mod + ` (eq? (lookup :env m) (lookup :env m)))`, `#t`},
+	}
+
+	checkResults(t, types.NewListEnv(), tcs)
+
+	checkErrors(t, types.NewEnv(), []errorCase{
+		{`(env-bindings {})`, "TypeError(env-bindings): expected env but dict"},
+	})
+}
+
+func TestModule(t *testing.T) {
+	tcs := []evalCase{
+		// later forms see the definitions of earlier ones
+		{`(env-bindings (lookup :env (module {:module 'm :doc "" :exports '[y]} (def x 1) (def y (+ x 1)))))`, `{x 1 y 2}`},
+		{`(lookup :exports (module {:module 'm :doc "" :exports '[x]} (def x 1) (def y 2)))`, `[x]`},
+		{`(do (def x 1) (module {:module 'm :doc "" :exports '[]} (def x 2)) x)`, `1`},
+	}
+
+	checkResults(t, types.NewEnv(), tcs)
+}
+
+func TestDo(t *testing.T) {
+	checkResults(t, types.NewEnv(), []evalCase{
+		{`(do)`, `()`},
+		{`(do (def x 1) (def x (+ x 1)) x)`, `2`},
+	})
+
+	// the first error stops evaluation
+	checkErrors(t, types.NewEnv(), []errorCase{
+		{`(do (+ 1 :a) (def x 1) x)`, "TypeError(+): expected number but keyword"},
+		{`(do (def x 1) (+ x :a) x)`, "TypeError(+): expected number but keyword"},
+		{`(do (def r (ref 1)) (write-ref r :a) (+ (read-ref r) 1) (write-ref r 2))`, "TypeError(+): expected number but keyword"},
+	})
+}
+
+func TestTailCalls(t *testing.T) {
+	tcs := []evalCase{
+		{`(do (def-rec loop (fn [n acc] (if (eq? n 0) acc (loop (- n 1) (+ acc 2)))))
+			(loop 1000000 0))`, `2000000`},
+		{`(do (def-rec loop (fn [n] (cond (eq? n 0) :done #t (do (def m (- n 1)) (loop m)))))
+			(loop 100000))`, `:done`},
+		{`(do (def-rec even? (fn [n] (if (eq? n 0) #t (do (def-rec odd? (fn [n] (if (eq? n 0) #f (even? (- n 1))))) (odd? (- n 1))))))
+			(even? 100001))`, `#f`},
+		{`(do (def f (fn [] (def x 1) 2)) (def x 0) (f) x)`, `0`},
+		{`(do (def x 0) (if #t (def x 1) 2) x)`, `1`},
+		{`(cond #f 1)`, `()`},
+	}
+
+	checkResults(t, types.NewListEnv(), tcs)
+}
+
+type primOpCosts struct {
+	DefaultCosts
+	cost uint64
+}
+
+func (c primOpCosts) PrimOp(Ident, []Value) uint64 { return c.cost }
+
+func TestGas(t *testing.T) {
+	tcs := []struct {
+		Input string
+		Costs Costs
+		Used  uint64
+	}{
+		// steps: the call, +, 1 and 2; one primop call; 3 is no larger than 1
+		{`(+ 1 2)`, nil, 5},
+		{`(+ 1 2)`, primOpCosts{cost: 100}, 104},
+		// 8 bytes result beyond the 4 of the largest argument
+		{`(string-append "aaaa" "bbbb")`, nil, 9},
+		// the vector and its elements are each a step, and the vector is
+		// 7 elements larger than them; the result shrinks
+		{`(rest [1 2 3 4 5 6 7 8])`, nil, 19},
+		// the quasiquote is a step, each literal 4 steps and 2 elements
+		// larger than its elements, and splicing them adds 3 elements
+		{"`[,@[1 2 3] ,@[4 5 6]]", nil, 16},
+	}
+
+	for _, tc := range tcs {
+		gas := NewGas(1000)
+		gas.Costs = tc.Costs
+		_, _, err := BaseEval(EmptyBindings().SetGas(gas), parse.Expr(tc.Input))
+		require.NoError(t, err, "Failed: %s", tc.Input)
+		require.Equal(t, tc.Used, gas.Used, "Wrong gas: %s", tc.Input)
+	}
+
+	gas := NewGas(100000)
+	_, _, err := BaseEval(EmptyBindings().SetGas(gas), parse.Expr(`(do (def-rec loop (fn [n] (loop (+ n 1)))) (loop 0))`))
+	require.True(t, errors.Is(err, ErrOutOfGas), "Wrong error: %v", err)
+	require.EqualError(t, err, "OutOfGas: used all 100000 gas")
+	require.Equal(t, uint64(100000), gas.Used)
+
+	_, _, err = BaseEval(EmptyBindings().SetGas(NewGas(10)), parse.Expr(`(do (def-rec loop (fn [n] (loop (+ n 1)))) (loop 0))`))
+	require.True(t, errors.Is(err, ErrOutOfGas), "Wrong error: %v", err)
+}
+
+func TestBaseEvalContext(t *testing.T) {
+	s := EmptyBindings()
+	s, _, err := BaseEval(s, parse.Expr(`(def r (ref 0))`))
+	require.NoError(t, err)
+
+	loop := parse.Expr(`(do (write-ref r 1) (def-rec loop (fn [n] (loop (+ n 1)))) (loop 0))`)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err = BaseEvalContext(ctx, s, loop)
+	require.True(t, errors.Is(err, context.DeadlineExceeded), "Wrong error: %v", err)
+	_, res, err := BaseEval(s, parse.Expr(`(read-ref r)`))
+	require.NoError(t, err)
+	require.Equal(t, parse.Expr(`0`), res, "Refs changed by cancelled evaluation")
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, _, err = BaseEvalContext(ctx, s, parse.Expr(`(+ 1 2)`))
+	require.True(t, errors.Is(err, context.Canceled), "Wrong error: %v", err)
+	require.EqualError(t, err, "Cancelled: context canceled")
+
+	s0, res, err := BaseEvalContext(context.Background(), s, parse.Expr(`(do (write-ref r 2) (read-ref r))`))
+	require.NoError(t, err)
+	require.Equal(t, parse.Expr(`2`), res)
+	_, res, err = BaseEval(s0, parse.Expr(`(read-ref r)`))
+	require.NoError(t, err)
+	require.Equal(t, parse.Expr(`2`), res)
+
+	// refs holding nil are restored rather than dropped
+	s, _, err = BaseEval(s, parse.Expr(`(def n (ref (do)))`))
+	require.NoError(t, err)
+	_, _, err = BaseEvalContext(context.Background(), s, parse.Expr(`(do (write-ref n 1) (throw 'x 1))`))
+	require.Error(t, err)
+	_, res, err = BaseEval(s, parse.Expr(`(read-ref n)`))
+	require.NoError(t, err)
+	require.Nil(t, res)
+	_, res, err = BaseEval(s, parse.Expr(`(eq? (ref 7) n)`))
+	require.NoError(t, err)
+	require.Equal(t, parse.Expr(`#f`), res)
+
+	// the refs of states evaluated with base-eval are restored too
+	refs := types.NewIntmap()
+	refs.Insert(types.NewNum(0))
+	s = s.SetEnv(s.Env.Set("st", types.NewState(types.NewEnv().Set("q", types.NewRef(0)), refs)))
+	nested := parse.Expr(`(base-eval '(do (write-ref q 1) (ref 2) (def-rec loop (fn [n] (loop (+ n 1)))) (loop 0)) st)`)
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err = BaseEvalContext(ctx, s, nested)
+	require.True(t, errors.Is(err, context.DeadlineExceeded), "Wrong error: %v", err)
+	res, _ = refs.Get(0)
+	require.Equal(t, parse.Expr(`0`), res, "Refs changed by cancelled evaluation")
+	require.Equal(t, 1, refs.Len())
+	require.Equal(t, uint64(1), refs.Insert(types.NewNum(1)))
+}
+
+func TestLimits(t *testing.T) {
+	tcs := []struct {
+		Input    string
+		Resource Resource
+	}{
+		{`(do (def-rec f (fn [n] (if (eq? n 0) 0 (+ 1 (f (- n 1)))))) (f 100000))`, ResourceDepth},
+		{strings.Repeat("[", 1000) + strings.Repeat("]", 1000), ResourceDepth},
+		{`(do (def-rec f (fn [n] (do (ref n) (f (+ n 1))))) (f 0))`, ResourceRefs},
+		{`(do (def-rec f (fn [s] (f (string-append s s)))) (f "ab"))`, ResourceSize},
+		{`(do (def-rec f (fn [v] (f (add-right v 0)))) (f []))`, ResourceSize},
+		{"(do (def-rec f (fn [v] (f `[,@v ,@v]))) (f [1]))", ResourceSize},
+		{"(do (def-rec f (fn [v] (f `(,@v ,@v)))) (f '(1)))", ResourceSize},
+	}
+
+	for _, tc := range tcs {
+		s := EmptyBindings().SetLimits(NewLimits(100, 10, 1000))
+		_, _, err := BaseEval(s, parse.Expr(tc.Input))
+		var lerr *ResourceLimitError
+		require.True(t, errors.As(err, &lerr), "Wrong error: %s: %v", tc.Input, err)
+		require.Equal(t, tc.Resource, lerr.Resource, "Wrong resource: %s", tc.Input)
+		require.Equal(t, 0, s.Limits.depth, "Depth not unwound: %s", tc.Input)
+	}
+
+	// refs cannot be added by writing to unused ones
+	checkErrors(t, types.NewEnv().Set("r", types.NewRef(5)), []errorCase{
+		{`(write-ref r 1)`, "Impossible(write-ref): undefined reference"},
+		{`(read-ref r)`, "Impossible(read-ref): undefined reference"},
+	})
+
+	// literals are built like primop results
+	for _, input := range []string{`[1 2 3 4]`, `{:a 1 :b 2 :c 3 :d 4}`, "`(1 2 3 4)", "`[,@[1 2] ,@[3 4]]"} {
+		_, _, err := BaseEval(EmptyBindings().SetLimits(NewLimits(100, 10, 3)), parse.Expr(input))
+		var lerr *ResourceLimitError
+		require.True(t, errors.As(err, &lerr), "Wrong error: %s: %v", input, err)
+		require.Equal(t, ResourceSize, lerr.Resource, "Wrong resource: %s", input)
+	}
+
+	// tail calls do not nest
+	s := EmptyBindings().SetLimits(NewLimits(100, 10, 1000))
+	_, res, err := BaseEval(s, parse.Expr(`(do (def-rec loop (fn [n] (if (eq? n 0) :done (loop (- n 1))))) (loop 10000))`))
+	require.NoError(t, err)
+	require.Equal(t, parse.Expr(`:done`), res)
+}
+
+func TestCatch(t *testing.T) {
+	tcs := []evalCase{
+		{`(catch 'x (throw 'x [1 {:a 2}]) (fn [l v] [l v]))`, `[x [1 {:a 2}]]`},
+		{`(catch 'any (throw 'x 1) (fn [l v] l))`, `x`},
+		{`(catch 'x (+ 1 2) (fn [l v] 0))`, `3`},
+		{`(catch 'x (catch 'y (throw 'x 1) (fn [l v] :inner)) (fn [l v] :outer))`, `:outer`},
+		{`(catch 'UnknownIdentifier undefined (fn [l v] [l v]))`, `[UnknownIdentifier {:label UnknownIdentifier :message "UnknownIdentifier: undefined" :ident undefined}]`},
+		{`(catch 'TypeError (+ 1 "a") (fn [l v] l))`, `TypeError`},
+		{`(do (def x 0) (catch 'x (do (def x 1) (throw 'x x)) (fn [l v] [x v])))`, `[0 1]`},
+		{`(do (def r (ref 0)) (catch 'any (do (write-ref r 1) (throw 'x 2)) (fn [l v] v)) (read-ref r))`, `1`},
+	}
+
+	checkResults(t, types.NewEnv(), tcs)
+
+	_, _, err := BaseEval(EmptyBindings(), parse.Expr(`(catch 'y (throw 'x 1) (fn [l v] 0))`))
+	var terr *ThrownError
+	require.True(t, errors.As(err, &terr), "Wrong error: %v", err)
+	require.Equal(t, Ident("x"), terr.Label)
+	require.Equal(t, parse.Expr(`1`), terr.Value)
+
+	_, _, err = BaseEval(EmptyBindings().SetGas(NewGas(1000)), parse.Expr(`(catch 'any (do (def-rec f (fn [] (f))) (f)) (fn [l v] 0))`))
+	require.True(t, errors.Is(err, ErrOutOfGas), "Wrong error: %v", err)
+}
+
+func TestErrorTypes(t *testing.T) {
+	spans := make(parse.Spans)
+	expr, err := parse.ReadSource("test.rad", `(+ 1 "a")`, spans)
+	require.NoError(t, err)
+	_, _, err = BaseEval(EmptyBindings().SetSpans(spans), expr)
+	var terr *TypeError
+	require.True(t, errors.As(err, &terr), "Wrong error: %v", err)
+	require.Equal(t, &TypeError{"+", TypeNumber, TypeString}, terr)
+
+	_, _, err = BaseEval(EmptyBindings(), parse.Expr(`((fn [x] x))`))
+	var werr *WrongNumberArgsError
+	require.True(t, errors.As(err, &werr), "Wrong error: %v", err)
+	require.Equal(t, &WrongNumberArgsError{"lambda", 1, 0}, werr)
+
+	_, _, err = BaseEval(EmptyBindings(), parse.Expr(`(nth 3 [1 2])`))
+	var rerr Error
+	require.True(t, errors.As(err, &rerr), "Wrong error: %v", err)
+	require.Equal(t, Ident("OutOfRange"), rerr.Kind())
+	require.Equal(t, parse.Expr(`{:label OutOfRange :message "OutOfRange(nth): index 3 out of range for length 2" :fn "nth" :index 3 :length 2}`), rerr.ToRadicle())
+
+	v := parseEval(types.NewEnv(), `(catch 'any (+ 1 "a") (fn [l v] [(lookup :fn v) (lookup :label v) (lookup :expected v) (lookup :actual v)]))`)
+	require.Equal(t, parse.Expr(`["+" TypeError :number :string]`), v)
+	require.Equal(t, "TypeError(+): expected number but string", terr.Error())
+}
@@ -0,0 +1,106 @@
+package radicle
+
+import (
+	"github.com/mossid/dr-alice/types"
+)
+
+// Costs prices the work done by evaluation.
+type Costs interface {
+	// Step is charged for every expression evaluated, including every
+	// iteration of a tail call loop.
+	Step(expr Value) uint64
+	// PrimOp is charged before a primop is called.
+	PrimOp(name Ident, args []Value) uint64
+	// Alloc is charged after a primop returns, for the memory its result
+	// takes beyond what it shares with its arguments. Vector and dict
+	// literals and quasiquoted lists, vectors and dicts are charged the
+	// same way, with the values they are built from as args.
+	Alloc(args []Value, res Value) uint64
+}
+
+// DefaultCosts charges 1 per step and per primop call, and 1 per element,
+// entry or byte a result has beyond its largest argument.
+type DefaultCosts struct{}
+
+func (DefaultCosts) Step(Value) uint64            { return 1 }
+func (DefaultCosts) PrimOp(Ident, []Value) uint64 { return 1 }
+func (DefaultCosts) Alloc(args []Value, res Value) uint64 {
+	var max uint64
+	for _, arg := range args {
+		if n := size(arg); n > max {
+			max = n
+		}
+	}
+	if n := size(res); n > max {
+		return n - max
+	}
+	return 0
+}
+
+// size approximates the top level size of v without walking it.
+func size(v Value) uint64 {
+	switch v := v.(type) {
+	case *String:
+		return uint64(len(v.Str()))
+	case *Dict:
+		return uint64(v.Len())
+	case types.Sequence:
+		return uint64(v.Length())
+	default:
+		return 1
+	}
+}
+
+// Gas is an evaluation budget. It is shared by all bindings derived from
+// the ones it was set on, and Used reports how much has been spent.
+type Gas struct {
+	Limit uint64
+	Used  uint64
+	// Costs defaults to DefaultCosts.
+	Costs Costs
+}
+
+func NewGas(limit uint64) *Gas {
+	return &Gas{Limit: limit}
+}
+
+func (g *Gas) costs() Costs {
+	if g.Costs == nil {
+		return DefaultCosts{}
+	}
+	return g.Costs
+}
+
+// charge spends n gas. A nil Gas is unlimited.
+func (g *Gas) charge(n uint64) error {
+	if g == nil {
+		return nil
+	}
+	if n > g.Limit-g.Used {
+		g.Used = g.Limit
+		return &OutOfGasError{g.Limit}
+	}
+	g.Used += n
+	return nil
+}
+
+func (g *Gas) step(expr Value) error {
+	if g == nil {
+		return nil
+	}
+	return g.charge(g.costs().Step(expr))
+}
+
+func (g *Gas) primOp(name Ident, args []Value) error {
+	if g == nil {
+		return nil
+	}
+	return g.charge(g.costs().PrimOp(name, args))
+}
+
+func (g *Gas) alloc(args []Value, res Value) error {
+	if g == nil {
+		return nil
+	}
+	return g.charge(g.costs().Alloc(args, res))
+}
@@ -0,0 +1,25 @@
+package radicle
+
+import (
+	"github.com/mossid/dr-alice/types"
+)
+
+// JSON primitives follow the mapping documented at types.ToJSON.
+func jsonPrimFns() []PrimOp {
+	return []PrimOp{
+		PrimOp{"to-json", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			bz, err := types.ToJSON(args[0])
+			if err != nil {
+				return nil, nil, &JSONError{"to-json", err}
+			}
+			return s, types.NewString(string(bz)), nil
+		}}.argn(1),
+		PrimOp{"from-json", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			res, err := types.FromJSON([]byte(args[0].(*String).Str()))
+			if err != nil {
+				return nil, nil, &JSONError{"from-json", err}
+			}
+			return s, res, nil
+		}}.argn(1).types(TypeString),
+	}
+}
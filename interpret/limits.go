@@ -0,0 +1,56 @@
+package radicle
+
+// Limits bounds the resources evaluation may hold at once, so that
+// runaway programs fail with a ResourceLimitError instead of exhausting
+// the Go stack or memory. A zero limit is unlimited. Like Gas, Limits is
+// shared by all bindings derived from the ones it was set on.
+type Limits struct {
+	// Depth limits the nesting of evaluation, i.e. of non-tail calls and
+	// subexpressions. It bounds the Go stack.
+	Depth int
+	// Refs limits the number of refs.
+	Refs int
+	// Size limits the length of strings, sequences and dicts returned by
+	// primops or built by literals and quasiquotes.
+	Size int
+
+	depth int
+}
+
+func NewLimits(depth, refs, size int) *Limits {
+	return &Limits{Depth: depth, Refs: refs, Size: size}
+}
+
+// enter records a nested evaluation, which must be matched by leave
+// unless it fails. A nil Limits is unlimited.
+func (l *Limits) enter() error {
+	if l == nil {
+		return nil
+	}
+	if l.Depth > 0 && l.depth >= l.Depth {
+		return &ResourceLimitError{ResourceDepth, l.Depth}
+	}
+	l.depth++
+	return nil
+}
+
+func (l *Limits) leave() {
+	if l != nil {
+		l.depth--
+	}
+}
+
+// ref checks that a ref can be added to n existing ones.
+func (l *Limits) ref(n int) error {
+	if l == nil || l.Refs <= 0 || n < l.Refs {
+		return nil
+	}
+	return &ResourceLimitError{ResourceRefs, l.Refs}
+}
+
+func (l *Limits) size(v Value) error {
+	if l == nil || l.Size <= 0 || size(v) <= uint64(l.Size) {
+		return nil
+	}
+	return &ResourceLimitError{ResourceSize, l.Size}
+}
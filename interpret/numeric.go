@@ -0,0 +1,132 @@
+package radicle
+
+import (
+	"math/big"
+
+	"github.com/mossid/dr-alice/types"
+)
+
+// Numbers are exact rationals, so arithmetic never overflows or rounds:
+// integers stay integers under +, - and *, and / yields a fraction when
+// the division is not exact. Overflow is only reported when a number has to
+// fit a machine integer, e.g. as an index.
+func numPrimFns() []PrimOp {
+	return []PrimOp{
+		PrimOp{"+", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			return s, foldNums(types.NewNum(0), args, (*Num).Add), nil
+		}}.alltypes(TypeNumber),
+		PrimOp{"*", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			return s, foldNums(types.NewNum(1), args, (*Num).Mul), nil
+		}}.alltypes(TypeNumber),
+		PrimOp{"-", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			if len(args) == 1 {
+				return s, args[0].(*Num).Neg(), nil
+			}
+			return s, foldNums(args[0].(*Num), args[1:], (*Num).Sub), nil
+		}}.argmin(1).alltypes(TypeNumber),
+		PrimOp{"/", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			acc, rest := args[0].(*Num), args[1:]
+			if len(args) == 1 {
+				acc, rest = types.NewNum(1), args
+			}
+			for _, arg := range rest {
+				n := arg.(*Num)
+				if n.Sign() == 0 {
+					return nil, nil, &DivisionByZeroError{"/"}
+				}
+				acc = acc.Quo(n)
+			}
+			return s, acc, nil
+		}}.argmin(1).alltypes(TypeNumber),
+		PrimOp{"quot", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			x, y, err := intArgs("quot", args)
+			if err != nil {
+				return nil, nil, err
+			}
+			return s, types.NewNumInt(new(big.Int).Quo(x, y)), nil
+		}}.argn(2).alltypes(TypeNumber),
+		PrimOp{"mod", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			x, y, err := intArgs("mod", args)
+			if err != nil {
+				return nil, nil, err
+			}
+			// Floored: the result has the sign of the divisor
+			r := new(big.Int).Rem(x, y)
+			if r.Sign() != 0 && r.Sign() != y.Sign() {
+				r.Add(r, y)
+			}
+			return s, types.NewNumInt(r), nil
+		}}.argn(2).alltypes(TypeNumber),
+		PrimOp{"abs", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			return s, args[0].(*Num).Abs(), nil
+		}}.argn(1).types(TypeNumber),
+		PrimOp{"min", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			return s, foldNums(args[0].(*Num), args[1:], func(x, y *Num) *Num {
+				if y.Cmp(x) < 0 {
+					return y
+				}
+				return x
+			}), nil
+		}}.argmin(1).alltypes(TypeNumber),
+		PrimOp{"max", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			return s, foldNums(args[0].(*Num), args[1:], func(x, y *Num) *Num {
+				if y.Cmp(x) > 0 {
+					return y
+				}
+				return x
+			}), nil
+		}}.argmin(1).alltypes(TypeNumber),
+		compareNums("<", func(c int) bool { return c < 0 }),
+		compareNums("<=", func(c int) bool { return c <= 0 }),
+		compareNums(">", func(c int) bool { return c > 0 }),
+		compareNums(">=", func(c int) bool { return c >= 0 }),
+		compareNums("=", func(c int) bool { return c == 0 }),
+	}
+}
+
+func foldNums(acc *Num, args []Value, f func(*Num, *Num) *Num) *Num {
+	for _, arg := range args {
+		acc = f(acc, arg.(*Num))
+	}
+	return acc
+}
+
+// compareNums builds a comparison that holds if ok is true for every
+// adjacent pair of arguments.
+func compareNums(name string, ok func(int) bool) PrimOp {
+	return PrimOp{name, func(s *Bindings, args []Value) (*Bindings, Value, error) {
+		for i := 1; i < len(args); i++ {
+			if !ok(args[i-1].(*Num).Cmp(args[i].(*Num))) {
+				return s, types.NewBool(false), nil
+			}
+		}
+		return s, types.NewBool(true), nil
+	}}.argmin(1).alltypes(TypeNumber)
+}
+
+// intArgs returns the two integer arguments of an integer division.
+func intArgs(fn string, args []Value) (x, y *big.Int, err error) {
+	for _, arg := range args {
+		if !arg.(*Num).IsInt() {
+			return nil, nil, &NonIntegerError{fn, arg}
+		}
+	}
+	y = args[1].(*Num).Rat().Num()
+	if y.Sign() == 0 {
+		return nil, nil, &DivisionByZeroError{fn}
+	}
+	return args[0].(*Num).Rat().Num(), y, nil
+}
+
+// intArg converts a number argument to an int.
+func intArg(fn string, v Value) (int, error) {
+	n := v.(*Num)
+	if !n.IsInt() {
+		return 0, &NonIntegerError{fn, v}
+	}
+	i, ok := n.Int64()
+	if !ok || int64(int(i)) != i {
+		return 0, &OverflowError{fn, v}
+	}
+	return int(i), nil
+}
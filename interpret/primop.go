@@ -1,6 +1,8 @@
 package radicle
 
 import (
+	"encoding/hex"
+
 	"github.com/mossid/dr-alice/types"
 )
 
@@ -14,19 +16,22 @@ type PrimOp struct {
 func (fn PrimOp) argn(n int) PrimOp {
 	run := func(s *Bindings, args []Value) (*Bindings, Value, error) {
 		if len(args) != n {
-			return nil, nil, WrongNumberArgsError(fn.Name, n, len(args))
+			return nil, nil, &WrongNumberArgsError{fn.Name, n, len(args)}
 		}
 		return fn.Run(s, args)
 	}
 	return PrimOp{fn.Name, run}
 }
 
+// types checks the types of the leading arguments. TypeNULL accepts any
+// type. Missing arguments are skipped and left to argn, which types runs
+// before when applied after it.
 func (fn PrimOp) types(tys ...ValueType) PrimOp {
 	run := func(s *Bindings, args []Value) (*Bindings, Value, error) {
 		for i, ty := range tys {
-			if ty != TypeNULL {
+			if i < len(args) && ty != TypeNULL {
 				if args[i].Type() != ty {
-					return nil, nil, TypeError(fn.Name, ty, args[i].Type())
+					return nil, nil, &TypeError{fn.Name, ty, args[i].Type()}
 				}
 			}
 		}
@@ -35,6 +40,30 @@ func (fn PrimOp) types(tys ...ValueType) PrimOp {
 	return PrimOp{fn.Name, run}
 }
 
+// argmin checks that at least n arguments were passed.
+func (fn PrimOp) argmin(n int) PrimOp {
+	run := func(s *Bindings, args []Value) (*Bindings, Value, error) {
+		if len(args) < n {
+			return nil, nil, &WrongNumberArgsError{fn.Name, n, len(args)}
+		}
+		return fn.Run(s, args)
+	}
+	return PrimOp{fn.Name, run}
+}
+
+// alltypes checks that every argument has type ty.
+func (fn PrimOp) alltypes(ty ValueType) PrimOp {
+	run := func(s *Bindings, args []Value) (*Bindings, Value, error) {
+		for _, arg := range args {
+			if arg.Type() != ty {
+				return nil, nil, &TypeError{fn.Name, ty, arg.Type()}
+			}
+		}
+		return fn.Run(s, args)
+	}
+	return PrimOp{fn.Name, run}
+}
+
 func MapPrimOpRuns(ops []PrimOp) func(Ident) PrimOpRun {
 	m := make(map[Ident]PrimOpRun)
 	for _, op := range ops {
@@ -45,7 +74,16 @@ func MapPrimOpRuns(ops []PrimOp) func(Ident) PrimOpRun {
 	}
 }
 
-func PurePrimFns() []PrimOp {
+func PurePrimFns() (res []PrimOp) {
+	res = append(res, corePrimFns()...)
+	res = append(res, numPrimFns()...)
+	res = append(res, stringPrimFns()...)
+	res = append(res, dictPrimFns()...)
+	res = append(res, jsonPrimFns()...)
+	return
+}
+
+func corePrimFns() []PrimOp {
 	return []PrimOp{
 		PrimOp{"base-eval", func(s *Bindings, args []Value) (*Bindings, Value, error) {
 			arg1 := args[1].(*State)
@@ -59,13 +97,21 @@ func PurePrimFns() []PrimOp {
 		/*
 			argn(0, PrimOp{"pure-state", func(s *Bindings, _ []Value) (*Bindings, Value, error) {
 				return s, (&Bindings{
-					Env: types.NewListEnv().Set("eval", types.NewPrimFn("base-eval")),
+					Env: types.NewEnv().Set("eval", types.NewPrimFn("base-eval")),
 				}).ToRadicle(), nil
 			}}),
 		*/
 		PrimOp{"state->env", func(s *Bindings, args []Value) (*Bindings, Value, error) {
 			return s, args[0].(*State).Env, nil
 		}}.argn(1).types(TypeState),
+		PrimOp{"env-bindings", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			res := types.NewDict()
+			args[0].(Env).Iterate(func(name Ident, v Value) bool {
+				res.Set(types.NewAtom(name), v)
+				return false
+			})
+			return s, res, nil
+		}}.argn(1).types(TypeEnv),
 		/*
 			PrimOp{"set-binding", func}
 			PrimOp{"get-binding"},
@@ -79,136 +125,143 @@ func PurePrimFns() []PrimOp {
 		}},
 		PrimOp{"dict", func(s *Bindings, args []Value) (*Bindings, Value, error) {
 			if len(args)%2 != 0 {
-				return nil, nil, WrongNumberArgsError("dict", 2, len(args))
+				return nil, nil, &WrongNumberArgsError{"dict", 2, len(args)}
 			}
 			return s, types.NewDict(args...), nil
 		}},
 		PrimOp{"throw", func(s *Bindings, args []Value) (*Bindings, Value, error) {
-			return nil, nil, ThrownError(args[0].(*Atom).Ident(), args[1])
+			return nil, nil, &ThrownError{args[0].(*Atom).Ident(), args[1]}
 		}}.argn(2).types(TypeAtom),
 		PrimOp{"eq?", func(s *Bindings, args []Value) (*Bindings, Value, error) {
 			return s, types.NewBool(args[0].Equal(args[1])), nil
 		}}.argn(2),
+		PrimOp{"hash", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			h := types.Hash(args[0])
+			return s, types.NewString(hex.EncodeToString(h[:])), nil
+		}}.argn(1),
 		PrimOp{"add-right", func(s *Bindings, args []Value) (*Bindings, Value, error) {
-			res := append(*args[0].(*Vector), args[1])
-			return s, &res, nil
+			return s, args[0].(*Vector).Append(args[1]), nil
 		}}.argn(2).types(TypeVec),
 		// Lists
 		PrimOp{"cons", func(s *Bindings, args []Value) (*Bindings, Value, error) {
 			switch tail := args[1].(type) {
 			case *List:
-				return s, &types.List{Head: args[0], Tail: tail}, nil
+				return s, types.Cons(args[0], tail), nil
 			case *Vector:
-				res := types.Vector(append([]Value{args[0]}, tail.Vector()...))
-				return s, &res, nil
+				return s, types.NewVector(append([]Value{args[0]}, tail.Vector()...)...), nil
 			default:
-				return nil, nil, TypeError("cons", TypeList, args[1].Type())
+				return nil, nil, &TypeError{"cons", TypeList, args[1].Type()}
 			}
 		}}.argn(2),
 		PrimOp{"first", func(s *Bindings, args []Value) (*Bindings, Value, error) {
 			switch list := args[0].(type) {
 			case *List:
 				if list == nil {
-					return nil, nil, OtherError("first", "Empty list")
+					return nil, nil, &OtherError{"first", "Empty list"}
 				}
 				return s, list.Head, nil
 			case *Vector:
-				if len(*list) == 0 {
-					return nil, nil, OtherError("first", "Empty vector")
+				if list.Length() == 0 {
+					return nil, nil, &OtherError{"first", "Empty vector"}
 				}
-				return s, (*list)[0], nil
+				return s, list.Index(0), nil
 			default:
-				return nil, nil, TypeError("first", TypeList, args[0].Type())
+				return nil, nil, &TypeError{"first", TypeList, args[0].Type()}
 			}
 		}}.argn(1),
 		PrimOp{"rest", func(s *Bindings, args []Value) (*Bindings, Value, error) {
 			switch list := args[0].(type) {
 			case *List:
 				if list == nil {
-					return nil, nil, OtherError("rest", "Empty List")
+					return nil, nil, &OtherError{"rest", "Empty List"}
 				}
 				return s, list.Tail, nil
 			case *Vector:
-				if len(*list) == 0 {
-					return nil, nil, OtherError("rest", "Empty vector")
+				if list.Length() == 0 {
+					return nil, nil, &OtherError{"rest", "Empty vector"}
 				}
-				res := (*list)[1:]
-				return s, &res, nil
+				return s, list.Slice(1, -1), nil
 			default:
-				return nil, nil, TypeError("rest", TypeList, args[0].Type())
+				return nil, nil, &TypeError{"rest", TypeList, args[0].Type()}
 			}
 		}}.argn(1),
 		// Sequences
 		PrimOp{"length", func(s *Bindings, args []Value) (*Bindings, Value, error) {
 			arg0, ok := args[0].(types.Sequence)
 			if !ok {
-				return nil, nil, TypeError("length", TypeList, args[0].Type())
+				return nil, nil, &TypeError{"length", TypeList, args[0].Type()}
 			}
 			return s, types.NewNum(int64(arg0.Length())), nil
 		}}.argn(1),
 		PrimOp{"drop", func(s *Bindings, args []Value) (*Bindings, Value, error) {
 			arg1, ok := args[1].(types.Sequence)
 			if !ok {
-				return nil, nil, TypeError("drop", TypeList, args[1].Type())
+				return nil, nil, &TypeError{"drop", TypeList, args[1].Type()}
 			}
-			return s, arg1.Slice(int(args[0].(*Num).Num()), -1), nil
+			n, err := intArg("drop", args[0])
+			if err != nil {
+				return nil, nil, err
+			}
+			if n < 0 || n > arg1.Length() {
+				return nil, nil, &OutOfRangeError{"drop", n, arg1.Length()}
+			}
+			return s, arg1.Slice(n, -1), nil
 		}}.argn(2).types(TypeNumber),
 		PrimOp{"take", func(s *Bindings, args []Value) (*Bindings, Value, error) {
 			arg1, ok := args[1].(types.Sequence)
 			if !ok {
-				return nil, nil, TypeError("take", TypeList, args[1].Type())
+				return nil, nil, &TypeError{"take", TypeList, args[1].Type()}
+			}
+			n, err := intArg("take", args[0])
+			if err != nil {
+				return nil, nil, err
+			}
+			if n < 0 || n > arg1.Length() {
+				return nil, nil, &OutOfRangeError{"take", n, arg1.Length()}
 			}
-			return s, arg1.Slice(0, int(args[0].(*Num).Num())), nil
+			return s, arg1.Slice(0, n), nil
 		}}.argn(2).types(TypeNumber),
 		PrimOp{"nth", func(s *Bindings, args []Value) (*Bindings, Value, error) {
 			switch list := args[1].(type) {
-
-			case *List, *Vector:
-				return s, list.(types.Sequence).Index(int(args[0].(*Num).Num())), nil
+			case *List, *Vector, *String:
+				seq := list.(types.Sequence)
+				n, err := intArg("nth", args[0])
+				if err != nil {
+					return nil, nil, err
+				}
+				if n < 0 || n >= seq.Length() {
+					return nil, nil, &OutOfRangeError{"nth", n, seq.Length()}
+				}
+				return s, seq.Index(n), nil
 			default:
-				return nil, nil, TypeError("nth", TypeList, args[1].Type())
+				return nil, nil, &TypeError{"nth", TypeList, args[1].Type()}
 			}
 		}}.argn(2).types(TypeNumber),
 		// PrimOp{"sort-by"}
 		// PrimOp{"zip"}
 		// PrimOp{"vec-to-list"}
 		// PrimOp{"list-to-vec"}
-		// Dicts
-		PrimOp{"lookup", func(s *Bindings, args []Value) (*Bindings, Value, error) {
-			res, ok := (*args[1].(*Dict))[args[0]]
-			if !ok {
-				return nil, nil, OtherError("lookup", "key did not exist: " /*, args[0]*/)
-			}
-			return s, res, nil
-		}}.argn(2).types(TypeNULL, TypeDict),
-		/*
-			PrimOp{"map-values", func(s *Bindings, args []Value) (*Bindings, Value, error) {
-				res := Dict(make(map[Value]Value))
-				for k, v := range *args[1].(*Dict) {
-					res[k] =
-				}
-			}}.argn(2).types(TypeNULL, TypeDict),
-		*/
 		// Ref
 		PrimOp{"ref", func(s *Bindings, args []Value) (*Bindings, Value, error) {
-			ix := s.Refs.Insert(args[0])
-			return s, types.NewRef(ix), nil
+			if err := s.Limits.ref(s.Refs.Len()); err != nil {
+				return nil, nil, err
+			}
+			return s, types.NewRef(s.newRef(args[0])), nil
 		}}.argn(1),
 		PrimOp{"read-ref", func(s *Bindings, args []Value) (*Bindings, Value, error) {
 			res, ok := s.Refs.Get(args[0].(*Ref).Uint())
 			if !ok {
-				return nil, nil, ImpossibleError("read-ref", "undefined reference")
+				return nil, nil, &ImpossibleError{"read-ref", "undefined reference"}
 			}
 			return s, res, nil
 		}}.argn(1).types(TypeRef),
 		PrimOp{"write-ref", func(s *Bindings, args []Value) (*Bindings, Value, error) {
-			v := args[1]
-			s.Refs.Set(args[0].(*Ref).Uint(), v)
+			ix, v := args[0].(*Ref).Uint(), args[1]
+			if _, ok := s.Refs.Get(ix); !ok {
+				return nil, nil, &ImpossibleError{"write-ref", "undefined reference"}
+			}
+			s.writeRef(ix, v)
 			return s, v, nil
 		}}.argn(2).types(TypeRef),
-		PrimOp{"+", func(s *Bindings, args []Value) (*Bindings, Value, error) {
-			// TODO: refactor num
-			return s, types.NewNum(int64(*args[0].(*Num) + *args[1].(*Num))), nil
-		}}.argn(2).types(TypeNumber, TypeNumber),
 	}
 }
@@ -4,25 +4,57 @@ import "github.com/mossid/dr-alice/types"
 
 type SpecialForm func(*Bindings, []Value) (*Bindings, Value, error)
 
-// TODO: catch & match
+// TailForm is a special form whose result is that of an expression in tail
+// position. It returns that expression, or nil if the result is nil,
+// with the bindings to evaluate it in.
+type TailForm func(*Bindings, []Value) (*Bindings, Value, error)
+
+func MapTailForm(id Ident) TailForm {
+	switch id {
+	case "do":
+		return do
+	case "if":
+		return iff
+	case "cond":
+		return cond
+	default:
+		return nil
+	}
+}
+
+// evalTail turns a tail form into a special form that evaluates the
+// expression in tail position.
+func evalTail(tf TailForm) SpecialForm {
+	return func(s *Bindings, v []Value) (*Bindings, Value, error) {
+		s0, expr, err := tf(s, v)
+		if err != nil || expr == nil {
+			return s0, nil, err
+		}
+		return BaseEval(s0, expr)
+	}
+}
+
+// TODO: match
 func MapSpecialForm(id Ident) SpecialForm {
 	switch id {
 	case "fn":
 		return fn
 	case "quote":
 		return quote
+	case "quasiquote":
+		return quasiquote
+	case "unquote", "unquote-splicing":
+		return unquote(id)
 	case "def":
 		return def
 	case "def-rec":
 		return defrec
-	case "do":
-		return do
-	case "if":
-		return iff
-	case "cond":
-		return cond
+	case "do", "if", "cond":
+		return evalTail(MapTailForm(id))
 	case "module":
 		return module
+	case "catch":
+		return catch
 	default:
 		return nil
 	}
@@ -30,21 +62,21 @@ func MapSpecialForm(id Ident) SpecialForm {
 
 func fn(s *Bindings, v []Value) (*Bindings, Value, error) {
 	if len(v) < 2 {
-		return nil, nil, SpecialFormError("fn", "need an argument vector and a body")
+		return nil, nil, &SpecialFormError{"fn", "need an argument vector and a body"}
 	}
 	args, bs := v[0], v[1:]
 
 	vargs, ok := args.(*Vector)
 	if !ok {
-		return nil, nil, SpecialFormError("fn", "first argument must be a vector of argument atoms")
+		return nil, nil, &SpecialFormError{"fn", "first argument must be a vector of argument atoms"}
 	}
 
-	iargs := make([]Ident, len(*vargs))
+	iargs := make([]Ident, vargs.Length())
 
 	for i, arg := range vargs.Vector() {
 		iarg, ok := arg.(*Atom)
 		if !ok {
-			return nil, nil, SpecialFormError("fn", "one of the arguments was not an atom")
+			return nil, nil, &SpecialFormError{"fn", "one of the arguments was not an atom"}
 		}
 		iargs[i] = iarg.Ident()
 	}
@@ -54,12 +86,157 @@ func fn(s *Bindings, v []Value) (*Bindings, Value, error) {
 
 func quote(s *Bindings, v []Value) (*Bindings, Value, error) {
 	if len(v) != 1 {
-		return nil, nil, WrongNumberArgsError("quote", 1, len(v))
+		return nil, nil, &WrongNumberArgsError{"quote", 1, len(v)}
 	}
 
 	return s, v[0], nil
 }
 
+func quasiquote(s *Bindings, v []Value) (*Bindings, Value, error) {
+	if len(v) != 1 {
+		return nil, nil, &WrongNumberArgsError{"quasiquote", 1, len(v)}
+	}
+
+	return quasi(s, v[0], 1)
+}
+
+func unquote(id Ident) SpecialForm {
+	return func(s *Bindings, v []Value) (*Bindings, Value, error) {
+		return nil, nil, &SpecialFormError{id, "used outside of quasiquote"}
+	}
+}
+
+// unwrap returns x if v is the form (name x).
+func unwrap(v Value, name Ident) (Value, bool) {
+	l, ok := v.(*List)
+	if !ok || l == nil || l.Tail == nil || l.Tail.Tail != nil {
+		return nil, false
+	}
+	a, ok := l.Head.(*Atom)
+	if !ok || a.Ident() != name {
+		return nil, false
+	}
+	return l.Tail.Head, true
+}
+
+// quasi copies the template v, evaluating unquoted holes at the given
+// quasiquote depth. Nested quasiquotes raise the depth and unquotes lower it,
+// so only holes belonging to the outermost quasiquote are evaluated.
+func quasi(s *Bindings, v Value, depth int) (*Bindings, Value, error) {
+	switch v := v.(type) {
+	case *List:
+		if x, ok := unwrap(v, "unquote"); ok {
+			if depth == 1 {
+				return BaseEval(s, x)
+			}
+			return requote(s, "unquote", x, depth-1)
+		}
+		if x, ok := unwrap(v, "quasiquote"); ok {
+			return requote(s, "quasiquote", x, depth+1)
+		}
+		if x, ok := unwrap(v, "unquote-splicing"); ok {
+			if depth == 1 {
+				return nil, nil, &SpecialFormError{"unquote-splicing", "used outside of a list or vector"}
+			}
+			return requote(s, "unquote-splicing", x, depth-1)
+		}
+		s0, xs, parts, err := quasiSeq(s, v.List(), depth)
+		if err != nil {
+			return nil, nil, err
+		}
+		res := types.NewList(xs...)
+		if err := s0.alloc(parts, res); err != nil {
+			return nil, nil, err
+		}
+		return s0, res, nil
+	case *Vector:
+		s0, xs, parts, err := quasiSeq(s, v.Vector(), depth)
+		if err != nil {
+			return nil, nil, err
+		}
+		res := types.NewVector(xs...)
+		if err := s0.alloc(parts, res); err != nil {
+			return nil, nil, err
+		}
+		return s0, res, nil
+	case *Dict:
+		res := types.NewDict()
+		var parts []Value
+		var err error
+		v.Iterate(func(k, v Value) bool {
+			var k0, v0 Value
+			s, k0, err = quasi(s, k, depth)
+			if err != nil {
+				return true
+			}
+			s, v0, err = quasi(s, v, depth)
+			if err != nil {
+				return true
+			}
+			res.Set(k0, v0)
+			parts = append(parts, k0, v0)
+			return false
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := s.alloc(parts, res); err != nil {
+			return nil, nil, err
+		}
+		return s, res, nil
+	default:
+		return s, v, nil
+	}
+}
+
+func requote(s *Bindings, name Ident, x Value, depth int) (*Bindings, Value, error) {
+	s0, res, err := quasi(s, x, depth)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s0, types.NewList(types.NewAtom(name), res), nil
+}
+
+// quasiSeq copies the elements of a list or vector template, splicing in
+// the elements of each (unquote-splicing x) at depth 1. It also returns
+// the parts the result is built from: the copied elements and the spliced
+// sequences.
+func quasiSeq(s *Bindings, vs []Value, depth int) (*Bindings, []Value, []Value, error) {
+	res := make([]Value, 0, len(vs))
+	parts := make([]Value, 0, len(vs))
+	for _, v := range vs {
+		x, ok := unwrap(v, "unquote-splicing")
+		if !ok || depth > 1 {
+			var v0 Value
+			var err error
+			s, v0, err = quasi(s, v, depth)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			res = append(res, v0)
+			parts = append(parts, v0)
+			continue
+		}
+
+		var spliced Value
+		var err error
+		s, spliced, err = BaseEval(s, x)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		switch spliced := spliced.(type) {
+		case *List:
+			res = append(res, spliced.List()...)
+		case *Vector:
+			res = append(res, spliced.Vector()...)
+		default:
+			return nil, nil, nil, &TypeError{"unquote-splicing", TypeList, spliced.Type()}
+		}
+		parts = append(parts, spliced)
+	}
+	return s, res, parts, nil
+}
+
 func defintern(s *Bindings, v []Value, isrec bool) (*Bindings, Value, error) {
 	var fnname string
 	if isrec {
@@ -69,12 +246,12 @@ func defintern(s *Bindings, v []Value, isrec bool) (*Bindings, Value, error) {
 	}
 
 	if len(v) != 2 {
-		return nil, nil, WrongNumberArgsError(fnname, 2, len(v))
+		return nil, nil, &WrongNumberArgsError{fnname, 2, len(v)}
 	}
 
 	name, ok := v[0].(*Atom)
 	if !ok {
-		return nil, nil, SpecialFormError(fnname, "expects atom for first arg")
+		return nil, nil, &SpecialFormError{fnname, "expects atom for first arg"}
 	}
 	ident := name.Ident()
 
@@ -97,9 +274,9 @@ func defintern(s *Bindings, v []Value, isrec bool) (*Bindings, Value, error) {
 		})
 		return s00, nil, nil
 	case *LambdaRec:
-		return nil, nil, SpecialFormError(fnname, "cannot be used to alias functions")
+		return nil, nil, &SpecialFormError{fnname, "cannot be used to alias functions"}
 	default:
-		return nil, nil, SpecialFormError(fnname, "can only be used to define functions")
+		return nil, nil, &SpecialFormError{fnname, "can only be used to define functions"}
 	}
 }
 
@@ -111,22 +288,63 @@ func defrec(s *Bindings, v []Value) (*Bindings, Value, error) {
 	return defintern(s, v, true)
 }
 
-func do(s *Bindings, v []Value) (s0 *Bindings, res Value, err error) {
-	s0 = s
-	for _, v0 := range v {
-		s0, res, err = BaseEval(s0, v0)
+func do(s *Bindings, v []Value) (*Bindings, Value, error) {
+	if len(v) == 0 {
+		return s, nil, nil
+	}
+	s0 := s
+	for _, v0 := range v[:len(v)-1] {
+		var err error
+		s0, _, err = BaseEval(s0, v0)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
-	return
+	return s0, v[len(v)-1], nil
 }
 
-/*
-func catch(s *Bindings, v []Value) (s0 *Bindings, res Value, err error) {
+// catch evaluates (catch 'label expr handler). If expr raises an error
+// with the label, the handler is called with the label and the thrown
+// value, and its result is that of catch. The label 'any catches every
+// error. Errors raised by the interpreter are labelled by their kind, like
+// 'TypeError, and thrown as a dict of their fields; see Error.
+func catch(s *Bindings, v []Value) (*Bindings, Value, error) {
+	if len(v) != 3 {
+		return nil, nil, &WrongNumberArgsError{"catch", 3, len(v)}
+	}
 
+	s0, label, err := BaseEval(s, v[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	alabel, ok := label.(*Atom)
+	if !ok {
+		return nil, nil, &TypeError{"catch", TypeAtom, label.Type()}
+	}
+
+	s1, res, err := BaseEval(s0, v[1])
+	if err == nil {
+		return s1, res, nil
+	}
+	tlabel, tv, ok := thrown(err)
+	if !ok || (alabel.Ident() != "any" && alabel.Ident() != tlabel) {
+		return nil, nil, err
+	}
+
+	s1, handler, err := BaseEval(s0, v[2])
+	if err != nil {
+		return nil, nil, err
+	}
+	s1, res, err = callFn(s1, handler, []Value{types.NewAtom(tlabel), tv})
+	if err != nil {
+		return nil, nil, err
+	}
+	return s1, res, nil
 }
-*/
+
 func iff(s *Bindings, v []Value) (*Bindings, Value, error) {
 	if len(v) != 3 {
-		return nil, nil, WrongNumberArgsError("if", 3, len(v))
+		return nil, nil, &WrongNumberArgsError{"if", 3, len(v)}
 	}
 
 	s0, cond, err := BaseEval(s, v[0])
@@ -143,31 +361,26 @@ func iff(s *Bindings, v []Value) (*Bindings, Value, error) {
 		}
 	}
 
-	return BaseEval(s0, body)
+	return s0, body, nil
 }
 
 func cond(s *Bindings, v []Value) (*Bindings, Value, error) {
 	if len(v)%2 != 0 {
-		return nil, nil, WrongNumberArgsError("cond", 2, len(v))
+		return nil, nil, &WrongNumberArgsError{"cond", 2, len(v)}
 	}
 
-	if len(v) == 0 {
-		return s, nil, nil
-	}
-
-	s0, c, err := BaseEval(s, v[0])
-	if err != nil {
-		return nil, nil, err
-	}
+	for ; len(v) != 0; v = v[2:] {
+		s0, c, err := BaseEval(s, v[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		s = s0
 
-	bcond, ok := c.(*Bool)
-	if ok {
-		if bcond.Bool() == false {
-			return cond(s0, v[2:])
+		if bcond, ok := c.(*Bool); !ok || bcond.Bool() {
+			return s, v[1], nil
 		}
 	}
-
-	return BaseEval(s0, v[1])
+	return s, nil, nil
 }
 
 func meta(v Value) (res ModuleMeta, err error) {
@@ -176,8 +389,7 @@ func meta(v Value) (res ModuleMeta, err error) {
 		err = InvalidDeclaration("must be dict", v)
 		return
 	}
-	d0 := *d
-	module, ok := d0[types.NewKeyword("module")]
+	module, ok := d.Get(types.NewKeyword("module"))
 	if !ok {
 		err = InvalidDeclaration("missing :module key", v)
 		return
@@ -187,7 +399,7 @@ func meta(v Value) (res ModuleMeta, err error) {
 		err = InvalidDeclaration(":module must be an atom", v)
 		return
 	}
-	doc, ok := d0[types.NewKeyword("doc")]
+	doc, ok := d.Get(types.NewKeyword("doc"))
 	if !ok {
 		err = InvalidDeclaration("missing :doc key", v)
 		return
@@ -197,7 +409,7 @@ func meta(v Value) (res ModuleMeta, err error) {
 		err = InvalidDeclaration(":doc must be a string", v)
 		return
 	}
-	exports, ok := d0[types.NewKeyword("exports")]
+	exports, ok := d.Get(types.NewKeyword("exports"))
 	if !ok {
 		err = InvalidDeclaration("missing :exports key", v)
 		return
@@ -219,11 +431,11 @@ func meta(v Value) (res ModuleMeta, err error) {
 		return
 	})
 
-	return ModuleMeta{module0.Ident(), es, doc0.String()}, nil
+	return ModuleMeta{module0.Ident(), es, doc0.Str()}, nil
 }
 func module(s *Bindings, v []Value) (*Bindings, Value, error) {
 	if len(v) < 1 {
-		return nil, nil, WrongNumberArgsError("module", 1, len(v))
+		return nil, nil, &WrongNumberArgsError{"module", 1, len(v)}
 	}
 	s0, m, err := BaseEval(s, v[0])
 	if err != nil {
@@ -235,7 +447,7 @@ func module(s *Bindings, v []Value) (*Bindings, Value, error) {
 	}
 	// XXX: make a new scope
 	for _, form := range v[1:] {
-		s0, _, err = BaseEval(s, form) // XXX: change to Eval
+		s0, _, err = BaseEval(s0, form) // XXX: change to Eval
 		if err != nil {
 			return nil, nil, err
 		}
@@ -266,10 +478,10 @@ func module(s *Bindings, v []Value) (*Bindings, Value, error) {
 /*
 func match(s *Bindings, v []Value) (*Bindings, Value, error) {
 	if len(v) < 1 {
-		return nil, nil, PatternMatchError("match", "no value")
+		return nil, nil, &PatternMatchError{"match", "no value"}
 	}
 	if len(v[1:])%2 != 0 {
-		return nil, nil, WrongNumberArgsError("match", 2, len(v))
+		return nil, nil, &WrongNumberArgsError{"match", 2, len(v)}
 	}
 
 	s0, v0, err := BaseEval(s, v[0])
@@ -282,7 +494,7 @@ func match(s *Bindings, v []Value) (*Bindings, Value, error) {
 
 func goMatches(s *Bindings, v Value, cases []Value) (*Bindings, Value, error {
 	if len(cases) == 0 {
-		return nil, nil, PatternMatchError("match", "no match")
+		return nil, nil, &PatternMatchError{"match", "no match"}
 	}
 
 	// Inlining match-pat primfn
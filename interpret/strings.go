@@ -0,0 +1,113 @@
+package radicle
+
+import (
+	"strings"
+
+	"github.com/mossid/dr-alice/parse"
+	"github.com/mossid/dr-alice/types"
+)
+
+// Strings are indexed by character (rune), not by byte.
+func stringPrimFns() []PrimOp {
+	return []PrimOp{
+		PrimOp{"string-append", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			var b strings.Builder
+			for _, arg := range args {
+				b.WriteString(arg.(*String).Str())
+			}
+			return s, types.NewString(b.String()), nil
+		}}.alltypes(TypeString),
+		PrimOp{"string-length", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			return s, types.NewNum(int64(args[0].(*String).Length())), nil
+		}}.argn(1).types(TypeString),
+		PrimOp{"substring", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			str := args[0].(*String)
+			begin, err := intArg("substring", args[1])
+			if err != nil {
+				return nil, nil, err
+			}
+			end, err := intArg("substring", args[2])
+			if err != nil {
+				return nil, nil, err
+			}
+			n := str.Length()
+			if begin < 0 || begin > n {
+				return nil, nil, &OutOfRangeError{"substring", begin, n}
+			}
+			if end < begin || end > n {
+				return nil, nil, &OutOfRangeError{"substring", end, n}
+			}
+			return s, str.Slice(begin, end), nil
+		}}.argn(3).types(TypeString, TypeNumber, TypeNumber),
+		PrimOp{"string-split", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			parts := strings.Split(args[1].(*String).Str(), args[0].(*String).Str())
+			res := make([]Value, len(parts))
+			for i, part := range parts {
+				res[i] = types.NewString(part)
+			}
+			return s, types.NewVector(res...), nil
+		}}.argn(2).types(TypeString, TypeString),
+		PrimOp{"string-join", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			strs, err := stringElems("string-join", args[1])
+			if err != nil {
+				return nil, nil, err
+			}
+			return s, types.NewString(strings.Join(strs, args[0].(*String).Str())), nil
+		}}.argn(2).types(TypeString),
+		PrimOp{"string-replace", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			from, to, str := args[0].(*String).Str(), args[1].(*String).Str(), args[2].(*String).Str()
+			return s, types.NewString(strings.Replace(str, from, to, -1)), nil
+		}}.argn(3).types(TypeString, TypeString, TypeString),
+		PrimOp{"string->list", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			var res []Value
+			args[0].(*String).Iterate(func(_ int, c Value) bool {
+				res = append(res, c)
+				return false
+			})
+			return s, types.NewList(res...), nil
+		}}.argn(1).types(TypeString),
+		PrimOp{"list->string", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			strs, err := stringElems("list->string", args[0])
+			if err != nil {
+				return nil, nil, err
+			}
+			return s, types.NewString(strings.Join(strs, "")), nil
+		}}.argn(1),
+		PrimOp{"string-upcase", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			return s, types.NewString(strings.ToUpper(args[0].(*String).Str())), nil
+		}}.argn(1).types(TypeString),
+		PrimOp{"string-downcase", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			return s, types.NewString(strings.ToLower(args[0].(*String).Str())), nil
+		}}.argn(1).types(TypeString),
+		PrimOp{"show", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			return s, types.NewString(args[0].String()), nil
+		}}.argn(1),
+		PrimOp{"read", func(s *Bindings, args []Value) (*Bindings, Value, error) {
+			res, err := parse.Read(args[0].(*String).Str())
+			if err != nil {
+				return nil, nil, &ParseError{"read", err}
+			}
+			return s, res, nil
+		}}.argn(1).types(TypeString),
+	}
+}
+
+// stringElems returns the elements of a list or vector of strings.
+func stringElems(fn string, v Value) ([]string, error) {
+	seq, ok := v.(types.Sequence)
+	if !ok || v.Type() == TypeString {
+		return nil, &TypeError{fn, TypeList, v.Type()}
+	}
+	res := make([]string, 0, seq.Length())
+	var err error
+	seq.Iterate(func(_ int, elem Value) bool {
+		str, ok := elem.(*String)
+		if !ok {
+			err = &TypeError{fn, TypeString, elem.Type()}
+			return true
+		}
+		res = append(res, str.Str())
+		return false
+	})
+	return res, err
+}
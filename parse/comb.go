@@ -3,16 +3,73 @@ package parse
 import (
 	"bytes"
 	"regexp"
+	"strconv"
 )
 
 type ParserState struct {
-	Stream []byte // io.Reader?
+	Stream []byte // see Decoder for reading from an io.Reader
+
+	// File names the source in spans and errors.
+	File string
+	// Spans, if not nil, receives the span of every value parsed.
+	Spans Spans
+
+	src      []byte
+	base     Pos // position of src[0] in the whole input, if not at the start
+	lines    []int
+	failed   bool
+	failOff  int
+	expected []string
+}
+
+func NewParserState(bz []byte) *ParserState {
+	return &ParserState{Stream: bz, src: bz}
 }
 
 func (st *ParserState) Lookahead(n int) string {
+	if n > len(st.Stream) {
+		n = len(st.Stream)
+	}
 	return string(st.Stream[:n])
 }
 
+// Offset returns the number of bytes consumed from the original input.
+func (st *ParserState) Offset() int {
+	if st.src == nil {
+		return 0
+	}
+	return len(st.src) - len(st.Stream)
+}
+
+// Fail records that a token of class exp was expected at the current
+// position. The furthest failure wins; failures at the same position are
+// merged. Always returns nil so it can be used as a parser result.
+func (st *ParserState) Fail(exp string) interface{} {
+	off := st.Offset()
+	switch {
+	case !st.failed || off > st.failOff:
+		st.failed = true
+		st.failOff = off
+		st.expected = []string{exp}
+	case off == st.failOff:
+		for _, e := range st.expected {
+			if e == exp {
+				return nil
+			}
+		}
+		st.expected = append(st.expected, exp)
+	}
+	return nil
+}
+
+// Err returns the furthest failure recorded by Fail, or nil.
+func (st *ParserState) Err() error {
+	if !st.failed {
+		return nil
+	}
+	return newError(st.File, st.src[st.failOff:], st.pos(st.failOff), st.expected)
+}
+
 func (st *ParserState) CheckString(s string) bool {
 	bz := []byte(s)
 	if len(st.Stream) < len(bz) {
@@ -80,12 +137,13 @@ type Parser func(*ParserState) interface{}
 
 type Empty struct{}
 
+// Try rewinds the stream if p fails. The failure recorded by p is kept.
 func Try(p Parser) Parser {
 	return func(st *ParserState) (res interface{}) {
-		orig := *st
+		orig := st.Stream
 		res = p(st)
 		if res == nil {
-			*st = orig
+			st.Stream = orig
 		}
 		return
 	}
@@ -99,40 +157,87 @@ func SkipMany(p Parser) Parser {
 	}
 }
 
+// Choice returns the result of the first parser that succeeds. Each
+// alternative starts from the same position.
 func Choice(ps ...Parser) Parser {
 	return func(st *ParserState) interface{} {
+		orig := st.Stream
 		for _, p := range ps {
 			if res := p(st); res != nil {
 				return res
 			}
+			st.Stream = orig
 		}
 		return nil
 	}
 }
 
+// Label records exp as the expected token class if p fails, and rewinds.
+func Label(exp string, p Parser) Parser {
+	return func(st *ParserState) interface{} {
+		orig := st.Stream
+		res := p(st)
+		if res == nil {
+			st.Stream = orig
+			return st.Fail(exp)
+		}
+		return res
+	}
+}
+
 func Space(spc Parser, lcp Parser, bc Parser) Parser {
 	return SkipMany(Choice(spc, lcp, bc))
 }
 
+// Lexeme skips trailing space after p only if p succeeded.
 func Lexeme(spc Parser, p Parser) Parser {
 	return func(st *ParserState) (res interface{}) {
 		res = p(st)
-		spc(st)
+		if res != nil {
+			spc(st)
+		}
 		return
 	}
 }
 
+// SkipLineComment skips from pref up to, but not including, the next newline.
 func SkipLineComment(pref string) Parser {
-	regex := regexp.MustCompile("^" + pref + ".*$")
+	regex := regexp.MustCompile("^" + pref + "[^\n]*")
 	return func(st *ParserState) interface{} {
 		return st.CheckConsumeEmpty(regex)
 	}
 }
 
+// SkipBlockComment skips a comment delimited by the literal strings start
+// and end. Comments nest, so every start must be matched by an end.
 func SkipBlockComment(start, end string) Parser {
-	regex := regexp.MustCompile("^" + start + ".*" + end)
+	bstart, bend := []byte(start), []byte(end)
 	return func(st *ParserState) interface{} {
-		return st.CheckConsumeEmpty(regex)
+		if !st.CheckString(start) {
+			return nil
+		}
+
+		rest := st.Stream
+		depth := 0
+		for {
+			switch {
+			case bytes.HasPrefix(rest, bstart):
+				depth++
+				rest = rest[len(bstart):]
+			case bytes.HasPrefix(rest, bend):
+				depth--
+				rest = rest[len(bend):]
+				if depth == 0 {
+					st.Consume(len(st.Stream) - len(rest))
+					return Empty{}
+				}
+			case len(rest) == 0:
+				st.Consume(len(st.Stream))
+				return st.Fail(strconv.Quote(end))
+			default:
+				rest = rest[1:]
+			}
+		}
 	}
 }
 
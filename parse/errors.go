@@ -0,0 +1,64 @@
+package parse
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const snippetLen = 20
+
+// Error is a syntax error at a position in the source.
+type Error struct {
+	File     string
+	Offset   int      // bytes from the start of the input
+	Line     int      // 1-based
+	Column   int      // 1-based, counted in runes
+	Expected []string // token classes that would have been accepted
+	Snippet  string   // source at Offset up to the end of the line, empty at end of input
+}
+
+func newError(file string, rest []byte, p Pos, expected []string) *Error {
+	if len(rest) > snippetLen {
+		rest = rest[:snippetLen]
+		for len(rest) > 0 && !utf8.Valid(rest) {
+			rest = rest[:len(rest)-1]
+		}
+	}
+	if i := bytes.IndexByte(rest, '\n'); i > 0 {
+		rest = rest[:i]
+	} else if i == 0 {
+		rest = rest[:1]
+	}
+
+	return &Error{
+		File:     file,
+		Offset:   p.Offset,
+		Line:     p.Line,
+		Column:   p.Column,
+		Expected: append([]string(nil), expected...),
+		Snippet:  string(rest),
+	}
+}
+
+func (err *Error) Error() string {
+	found := "end of input"
+	if err.Snippet != "" {
+		found = strconv.Quote(err.Snippet)
+	}
+	sp := Span{File: err.File, Start: Pos{err.Offset, err.Line, err.Column}}
+	return fmt.Sprintf("%s: expected %s but found %s", sp, orList(err.Expected), found)
+}
+
+func orList(xs []string) string {
+	switch len(xs) {
+	case 0:
+		return "nothing"
+	case 1:
+		return xs[0]
+	default:
+		return strings.Join(xs[:len(xs)-1], ", ") + " or " + xs[len(xs)-1]
+	}
+}
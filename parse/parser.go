@@ -1,79 +1,211 @@
 package parse
 
 import (
+	"bytes"
 	"regexp"
 	"strconv"
+	"unicode/utf8"
 
 	"github.com/mossid/dr-alice/types"
 )
 
+// Read parses str as a single value. Anything other than whitespace and
+// comments after the value is an error. Errors are of type *Error.
+func Read(str string) (types.Value, error) {
+	return ReadSource("", str, nil)
+}
+
+// ReadSource is like Read, but names the input file for error messages and
+// records the span of every parsed value in spans if it is not nil.
+func ReadSource(file string, str string, spans Spans) (types.Value, error) {
+	st := NewParserState([]byte(str))
+	st.File = file
+	st.Spans = spans
+	res, err := leadingValue(st)
+	if err != nil {
+		return nil, err
+	}
+	if len(st.Stream) != 0 {
+		st.Fail("end of input")
+		return nil, st.Err()
+	}
+	return res, nil
+}
+
+// SafeExpr parses the first value in str and ignores the rest.
 func SafeExpr(str string) (res types.Value, ok bool) {
-	st := &ParserState{[]byte(str)}
-	spaceConsume(st)
-	res, ok = Value(st).(types.Value)
-	spaceConsume(st)
-	return
+	res, err := leadingValue(NewParserState([]byte(str)))
+	return res, err == nil
 }
 
+// Expr is like SafeExpr but panics on a syntax error.
 func Expr(str string) types.Value {
-	res, ok := SafeExpr(str)
-	if !ok {
-		panic("panicccccc")
+	res, err := leadingValue(NewParserState([]byte(str)))
+	if err != nil {
+		panic(err)
 	}
 	return res
 }
 
+func leadingValue(st *ParserState) (types.Value, error) {
+	spaceConsume(st)
+	res, ok := Value(st).(types.Value)
+	if !ok {
+		return nil, st.Err()
+	}
+	spaceConsume(st)
+	return res, nil
+}
+
 func Value(st *ParserState) interface{} {
-	v, ok := Choice(
+	v, ok := datum(st).(types.Value)
+	if !ok {
+		return nil
+	}
+
+	spaceConsume(st)
+
+	return v
+}
+
+// datum parses a value without the trailing space, so its span ends at the
+// last byte of the value itself.
+func datum(st *ParserState) interface{} {
+	start := st.Offset()
+	v, ok := Label("value", Choice(
 		StringLiteral,
 		BoolLiteral,
 		Keyword,
 		NumLiteral,
 		Atom,
 		Quote,
+		Quasiquote,
+		UnquoteSplicing,
+		Unquote,
 		List,
 		Vec,
 		Dict,
-	)(st).(types.Value)
+	))(st).(types.Value)
 	if !ok {
 		return nil
 	}
 
-	spaceConsume(st)
+	st.record(v, start)
 
 	return v
 }
 
 var skipLineComment = SkipLineComment(`;;`)
-var skipBlockComment = SkipBlockComment(`#\|`, `\|#`)
+var skipBlockComment = SkipBlockComment(`#|`, `|#`)
 
 func spaceConsume(st *ParserState) interface{} {
-	return Space(Space1, skipLineComment, skipBlockComment)(st)
+	return Space(Space1, skipLineComment, Choice(skipBlockComment, DatumComment))(st)
 }
 
-var stringLiteralMatch = regexp.MustCompile(`^"[a-zA-Z0-9 ]*"`)
+// DatumComment skips #_ and the value following it.
+func DatumComment(st *ParserState) interface{} {
+	if st.CheckConsumeStringEmpty("#_") == nil {
+		return nil
+	}
+
+	spaceConsume(st)
+
+	spans := st.Spans
+	st.Spans = nil
+	v := datum(st)
+	st.Spans = spans
+	if v == nil {
+		return nil
+	}
+
+	return Empty{}
+}
 
+// StringLiteral reads a double quoted string. The escapes \", \\, \n, \t,
+// \u{hex} (a code point) and \x{hex} (a raw byte) are recognised; anything
+// else, including newlines, is taken literally.
 func StringLiteral(st *ParserState) interface{} {
-	// XXX: escape
-	str, ok := st.CheckConsume(stringLiteralMatch)
-	if !ok {
+	if st.CheckConsumeStringEmpty(`"`) == nil {
 		return nil
 	}
 
-	spaceConsume(st)
+	var buf []byte
+	for {
+		if len(st.Stream) == 0 {
+			return st.Fail(`closing '"'`)
+		}
 
-	return types.NewString(str[1 : len(str)-1])
+		switch c := st.Stream[0]; c {
+		case '"':
+			st.Consume(1)
+			return types.NewString(string(buf))
+		case '\\':
+			bz, n := unescape(st.Stream)
+			if n == 0 {
+				return st.Fail("escape sequence")
+			}
+			buf = append(buf, bz...)
+			st.Consume(n)
+		default:
+			buf = append(buf, c)
+			st.Consume(1)
+		}
+	}
+}
+
+// unescape decodes the escape sequence at the start of bz. It returns the
+// decoded bytes and the length of the sequence, which is 0 if it is invalid.
+func unescape(bz []byte) ([]byte, int) {
+	if len(bz) < 2 {
+		return nil, 0
+	}
+
+	switch bz[1] {
+	case '"', '\\':
+		return bz[1:2], 2
+	case 'n':
+		return []byte{'\n'}, 2
+	case 't':
+		return []byte{'\t'}, 2
+	case 'u', 'x':
+	default:
+		return nil, 0
+	}
+
+	if len(bz) > len(`\u{10ffff}`) {
+		bz = bz[:len(`\u{10ffff}`)]
+	}
+	end := bytes.IndexByte(bz, '}')
+	if len(bz) < 4 || bz[2] != '{' || end < 4 {
+		return nil, 0
+	}
+	i, err := strconv.ParseUint(string(bz[3:end]), 16, 32)
+	if err != nil {
+		return nil, 0
+	}
+
+	if bz[1] == 'x' {
+		if i > 0xff {
+			return nil, 0
+		}
+		return []byte{byte(i)}, end + 1
+	}
+	r := rune(i)
+	if !utf8.ValidRune(r) {
+		return nil, 0
+	}
+	res := make([]byte, utf8.RuneLen(r))
+	utf8.EncodeRune(res, r)
+	return res, end + 1
 }
 
 var boolLiteralMatch = regexp.MustCompile(`^#(t|f)`)
 
 func BoolLiteral(st *ParserState) (res interface{}) {
 	if st.CheckConsumeStringEmpty("#t") != nil {
-		spaceConsume(st)
 		return types.NewBool(true)
 	}
 	if st.CheckConsumeStringEmpty("#f") != nil {
-		spaceConsume(st)
 		return types.NewBool(false)
 	}
 	return nil
@@ -89,12 +221,10 @@ func Keyword(st *ParserState) interface{} {
 		return nil
 	}
 
-	spaceConsume(st)
-
 	return types.NewKeyword(kw)
 }
 
-var identFirstMatch = regexp.MustCompile(`^[A-Za-z!$%&*+-./<=>?@^_~]`)
+var identFirstMatch = regexp.MustCompile(`^[A-Za-z!$%&*+\-./<=>?@^_~]`)
 
 func identFirst(st *ParserState) interface{} {
 	str, ok := st.CheckConsume(identFirstMatch)
@@ -104,7 +234,7 @@ func identFirst(st *ParserState) interface{} {
 	return str
 }
 
-var identRestMatch = regexp.MustCompile(`^[A-Za-z0-9!$%&*+-./:<=>?@^_~]*`)
+var identRestMatch = regexp.MustCompile(`^[A-Za-z0-9!$%&*+\-./:<=>?@^_~]*`)
 
 func identRest(st *ParserState) interface{} {
 	str, ok := st.CheckConsume(identRestMatch)
@@ -114,26 +244,23 @@ func identRest(st *ParserState) interface{} {
 	return str
 }
 
-var numLiteralMatch = regexp.MustCompile(`^[+-]?[0-9]+`)
+var numLiteralMatch = regexp.MustCompile(`^[+-]?[0-9]+(\.[0-9]+|/[0-9]+)?`)
 
+// NumLiteral reads an integer, a decimal such as 1.25 or a fraction such as
+// 3/4. All of them are exact.
 func NumLiteral(st *ParserState) interface{} {
-
-	// TODO: points and rationals
 	str, ok := st.CheckConsume(numLiteralMatch)
 	if !ok {
-
 		return nil
 	}
 
-	i, err := strconv.ParseInt(str, 10, 64)
-	if err != nil {
-
+	// Fails on a zero denominator
+	n, ok := types.ParseNum(str)
+	if !ok {
 		return nil
 	}
 
-	spaceConsume(st)
-
-	return types.NewNum(i)
+	return n
 }
 
 func Atom(st *ParserState) interface{} {
@@ -147,22 +274,37 @@ func Atom(st *ParserState) interface{} {
 		return nil
 	}
 
-	spaceConsume(st)
-
 	return types.NewAtom(l + r)
 }
 
 func Quote(st *ParserState) interface{} {
-	if st.CheckConsumeStringEmpty("'") == nil {
+	return prefixed(st, "'", "quote")
+}
+
+func Quasiquote(st *ParserState) interface{} {
+	return prefixed(st, "`", "quasiquote")
+}
+
+func Unquote(st *ParserState) interface{} {
+	return prefixed(st, ",", "unquote")
+}
+
+func UnquoteSplicing(st *ParserState) interface{} {
+	return prefixed(st, ",@", "unquote-splicing")
+}
+
+// prefixed reads pref followed by a value as (name value).
+func prefixed(st *ParserState, pref string, name string) interface{} {
+	if st.CheckConsumeStringEmpty(pref) == nil {
 		return nil
 	}
 
-	val, ok := Value(st).(types.Value)
+	val, ok := datum(st).(types.Value)
 	if !ok {
 		return nil
 	}
 
-	return types.NewList(types.NewAtom("quote"), val)
+	return types.NewList(types.NewAtom(name), val)
 }
 
 func List(st *ParserState) interface{} {
@@ -182,7 +324,7 @@ func List(st *ParserState) interface{} {
 
 		v, ok := Value(st).(types.Value)
 		if !ok {
-			return nil
+			return st.Fail(`")"`)
 		}
 
 		vs = append(vs, v)
@@ -205,7 +347,7 @@ func Vec(st *ParserState) interface{} {
 
 		v, ok := Value(st).(types.Value)
 		if !ok {
-			return nil
+			return st.Fail(`"]"`)
 		}
 		vs = append(vs, v)
 		spaceConsume(st)
@@ -227,7 +369,7 @@ func Dict(st *ParserState) interface{} {
 
 		k, ok := Value(st).(types.Value)
 		if !ok {
-			return nil
+			return st.Fail(`"}"`)
 		}
 		v, ok := Value(st).(types.Value)
 		if !ok {
@@ -2,21 +2,28 @@ package parse
 
 import (
 	"fmt"
+	"io"
 	"math/rand"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/mossid/dr-alice/types"
 )
 
-var yesstrings = []string{`"hello"`, `"world world"`, `""`}
-var nostrings = []string{`"pppp`, `"`}
+var yesstrings = []string{`"hello"`, `"world world"`, `""`, `"(punct: 'a', ;; b #| c |#)"`,
+	`"\"\\\n\t"`, "\"multi\nline\"", `"\u{48}\u{1F600}\x{ff}"`, `"héllo wörld"`}
+var nostrings = []string{`"pppp`, `"`, `"\q"`, `"\u{}"`, `"\u{110000}"`, `"\x{100}"`, `"\u{48"`}
 var yesbools = []string{`#t`, `#f`}
 var nobools = []string{`#`}
 var keywords = []string{`:hello`, `:00123`, `:$@//`}
-var nums = []string{`+36`, `-9986`, `324567`}
+var nums = []string{`+36`, `-9986`, `324567`, `123456789012345678901234567890`, `1.25`, `-0.001`, `3/4`, `-10/4`}
 var atoms = []string{`atom`, `<atom1234@:`}
-var comments = []string{";;line comment\n", "#|\nblock\ncommment\n|#"} // TODO
+var comments = []string{";;line comment\n", "#|\nblock\ncommment\n|#", "#| outer #| inner\n|# |#",
+	"#|;; not a line comment|#", ";; #| not a block comment\n", "#_ :discarded", "#_(discarded #_ twice)", "#_\n#_ a b"}
+var nocomments = []string{"#| unclosed", "#| #| nested |#", "#_", "#_ )"}
 
 var values0 = concat(yesstrings, yesbools, keywords, nums, atoms)
 var quotes0 = quote(values0)
@@ -51,8 +58,11 @@ func gen(b, e string, vs []string) (res []string) {
 		elems := make([]string, 6)
 		for i := range elems {
 			elems[i] = vs[rand.Intn(len(vs))]
+			if rand.Intn(3) == 0 {
+				elems[i] = comments[rand.Intn(len(comments))] + " " + elems[i]
+			}
 		}
-		res[n] = b + strings.Join(elems, " ") + e
+		res[n] = b + strings.Join(elems, " ") + " " + comments[rand.Intn(len(comments))] + e
 	}
 	return
 }
@@ -79,102 +89,357 @@ func quote(vs []string) (res []string) {
 
 func TestValue(t *testing.T) {
 	for _, str := range values {
-		require.NotNil(t, Value(&ParserState{[]byte(str)}), "Failed: %s", str)
+		require.NotNil(t, Value(NewParserState([]byte(str))), "Failed: %s", str)
 	}
 
 	for _, str := range concat(nostrings, nobools) {
-		require.Nil(t, Value(&ParserState{[]byte(str)}), "Not failed: %s", str)
+		require.Nil(t, Value(NewParserState([]byte(str))), "Not failed: %s", str)
+	}
+}
+
+func TestComments(t *testing.T) {
+	for _, str := range comments {
+		st := NewParserState([]byte(str + " x"))
+		spaceConsume(st)
+		require.Equal(t, "x", string(st.Stream), "Failed: %s", str)
+
+		v, err := Read(str + " (" + str + " x " + str + ") " + str)
+		require.NoError(t, err, "Failed: %s", str)
+		require.True(t, Expr("(x)").Equal(v), "Not equal: %s, %s", str, v)
+	}
+
+	for _, str := range nocomments {
+		_, err := Read(str + " x")
+		require.Error(t, err, "Not failed: %s", str)
 	}
+
+	_, err := Read("(a #| b #| c |# d")
+	require.EqualError(t, err, `1:18: expected "|#" but found end of input`)
 }
 
 func TestStringLiteral(t *testing.T) {
 	for _, str := range yesstrings {
-		require.NotNil(t, StringLiteral(&ParserState{[]byte(str)}), "Failed: %s", str)
+		require.NotNil(t, StringLiteral(NewParserState([]byte(str))), "Failed: %s", str)
 	}
 
 	for _, str := range concat(nostrings, yesbools, nobools, keywords, nums, atoms) {
-		require.Nil(t, StringLiteral(&ParserState{[]byte(str)}), "Not failed: %s", str)
+		require.Nil(t, StringLiteral(NewParserState([]byte(str))), "Not failed: %s", str)
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	strs := []string{"", `"`, `\`, "\n\t\r\x00", "héllo", "\U0001F600", "\xff\xfe", "\u2028", "\ufffd"}
+	for i := 0; i < 100; i++ {
+		bz := make([]byte, rand.Intn(20))
+		rand.Read(bz)
+		strs = append(strs, string(bz))
+	}
+
+	for _, str := range strs {
+		v := types.NewString(str)
+		res, err := Read(v.String())
+		require.NoError(t, err, "Failed: %q", str)
+		require.True(t, v.Equal(res), "Not equal: %q", str)
+	}
+
+	v, err := Read(`"\u{48}\u{1F600}\x{ff}"`)
+	require.NoError(t, err)
+	require.Equal(t, "H\U0001F600\xff", v.(*types.String).Str())
+}
+
+func TestPrinting(t *testing.T) {
+	for _, str := range values {
+		v, err := Read(str)
+		require.NoError(t, err, "Failed: %s", str)
+		printed := v.String()
+		res, err := Read(printed)
+		require.NoError(t, err, "Failed to reread: %s", printed)
+		require.True(t, v.Equal(res), "Not equal: %s and %s", str, printed)
+		require.Equal(t, printed, res.String(), "Not canonical: %s", str)
 	}
+
+	v, err := Read(`{:b [1 "x\ty"] :a '(0.50 -6/4) "k" #t}`)
+	require.NoError(t, err)
+	require.Equal(t, `{:a (quote (0.5 -1.5)) :b [1 "x\ty"] "k" #t}`, v.String())
 }
 
 func TestBoolLiteral(t *testing.T) {
 	for _, str := range yesbools {
-		require.NotNil(t, BoolLiteral(&ParserState{[]byte(str)}), "Failed: %s", str)
+		require.NotNil(t, BoolLiteral(NewParserState([]byte(str))), "Failed: %s", str)
 	}
 
 	for _, str := range concat(yesstrings, nostrings, nobools, keywords, nums, atoms) {
-		require.Nil(t, BoolLiteral(&ParserState{[]byte(str)}), "Not failed: %s", str)
+		require.Nil(t, BoolLiteral(NewParserState([]byte(str))), "Not failed: %s", str)
 	}
 }
 
 func TestKeyword(t *testing.T) {
 	for _, str := range keywords {
-		require.NotNil(t, Keyword(&ParserState{[]byte(str)}), "Failed: %s", str)
+		require.NotNil(t, Keyword(NewParserState([]byte(str))), "Failed: %s", str)
 	}
 
 	for _, str := range concat(yesstrings, nostrings, yesbools, nobools, nums, atoms) {
-		require.Nil(t, Keyword(&ParserState{[]byte(str)}), "Not failed: %s", str)
+		require.Nil(t, Keyword(NewParserState([]byte(str))), "Not failed: %s", str)
 	}
 }
 
 func TestNum(t *testing.T) {
 	for _, str := range nums {
-		require.NotNil(t, NumLiteral(&ParserState{[]byte(str)}), "Failed: %s", str)
+		require.NotNil(t, NumLiteral(NewParserState([]byte(str))), "Failed: %s", str)
 	}
 
 	for _, str := range concat(yesstrings, nostrings, yesbools, nobools, keywords, atoms) {
-		require.Nil(t, NumLiteral(&ParserState{[]byte(str)}), "Not failed: %s", str)
+		require.Nil(t, NumLiteral(NewParserState([]byte(str))), "Not failed: %s", str)
+	}
+}
+
+func TestNumPrinting(t *testing.T) {
+	tcs := []struct {
+		input, output string
+	}{
+		{"+36", "36"},
+		{"-0", "0"},
+		{"123456789012345678901234567890", "123456789012345678901234567890"},
+		{"1.250", "1.25"},
+		{"-0.001", "-0.001"},
+		{"10/4", "2.5"},
+		{"-1/3", "-1/3"},
+		{"4/2", "2"},
+		{"1/80", "0.0125"},
+	}
+
+	for _, tc := range tcs {
+		v, err := Read(tc.input)
+		require.NoError(t, err, "Failed: %s", tc.input)
+		require.Equal(t, tc.output, v.String())
+		require.True(t, v.Equal(Expr(v.String())), "Not equal: %s", tc.input)
 	}
+
+	_, err := Read("1/0")
+	require.Error(t, err)
 }
 
 func TestAtom(t *testing.T) {
 	for _, str := range atoms {
-		require.NotNil(t, Atom(&ParserState{[]byte(str)}), "Failed: %s", str)
+		require.NotNil(t, Atom(NewParserState([]byte(str))), "Failed: %s", str)
 	}
 
 	// Nums can be parsed as atoms
 	for _, str := range concat(yesstrings, nostrings, yesbools, nobools, keywords) {
-		require.Nil(t, Atom(&ParserState{[]byte(str)}), "Not failed: %s", str)
+		require.Nil(t, Atom(NewParserState([]byte(str))), "Not failed: %s", str)
 	}
 }
 
 func TestQuote(t *testing.T) {
 	for _, str := range quotes1 {
-		require.NotNil(t, Quote(&ParserState{[]byte("'" + str)}), "Failed: %s", str)
+		require.NotNil(t, Quote(NewParserState([]byte("'"+str))), "Failed: %s", str)
 	}
 
 	for _, str := range concat(values0, lists0, vecs0, lists1, vecs1) {
-		require.Nil(t, Quote(&ParserState{[]byte(str)}), "Not failed: %s", str)
+		require.Nil(t, Quote(NewParserState([]byte(str))), "Not failed: %s", str)
+	}
+}
+
+func TestQuasiquote(t *testing.T) {
+	for _, str := range values1 {
+		for _, pref := range []string{"`", ",", ",@", "`,@"} {
+			require.NotNil(t, Value(NewParserState([]byte(pref+str))), "Failed: %s", pref+str)
+		}
 	}
+
+	require.True(t, Expr("(quasiquote (a (unquote b) (unquote-splicing c) (quote d)))").
+		Equal(Expr("`(a ,b ,@c 'd)")))
+	require.True(t, Expr("(a (unquote b))").Equal(Expr("(a,b)")))
+	require.Nil(t, Value(NewParserState([]byte("`"))))
 }
 
 func TestList(t *testing.T) {
 	for _, str := range lists1 {
-		require.NotNil(t, List(&ParserState{[]byte(str)}), "Failed: %s", str)
+		require.NotNil(t, List(NewParserState([]byte(str))), "Failed: %s", str)
 	}
 
 	for _, str := range values0 {
-		require.Nil(t, List(&ParserState{[]byte(str)}), "Not failed: %s", str)
+		require.Nil(t, List(NewParserState([]byte(str))), "Not failed: %s", str)
 	}
 }
 
 func TestVec(t *testing.T) {
 	for _, str := range vecs1 {
-		require.NotNil(t, Vec(&ParserState{[]byte(str)}), "Failed: %s", str)
+		require.NotNil(t, Vec(NewParserState([]byte(str))), "Failed: %s", str)
 	}
 
 	for _, str := range values0 {
-		require.Nil(t, Vec(&ParserState{[]byte(str)}), "Not failed: %s", str)
+		require.Nil(t, Vec(NewParserState([]byte(str))), "Not failed: %s", str)
 	}
 
 }
 
+func TestRead(t *testing.T) {
+	for _, str := range values {
+		_, err := Read(str)
+		require.NoError(t, err, "Failed: %s", str)
+	}
+
+	tcs := []struct {
+		input    string
+		line     int
+		column   int
+		expected []string
+		snippet  string
+	}{
+		{`(a b`, 1, 5, []string{"value", `")"`}, ""},
+		{"[1 2\n  #]", 2, 3, []string{"value", `"]"`}, "#]"},
+		{`{:a 1 :b (c }`, 1, 13, []string{"value", `")"`}, "}"},
+		{`(a b))`, 1, 6, []string{"end of input"}, ")"},
+		{`'`, 1, 2, []string{"value"}, ""},
+		{"(\"ab\" #", 1, 7, []string{"value", `")"`}, "#"},
+		{"(\"é\" #", 1, 6, []string{"value", `")"`}, "#"},
+		{"\"ab\ncd", 2, 3, []string{`closing '"'`}, ""},
+		{`["a\qb"]`, 1, 4, []string{"escape sequence"}, `\qb"]`},
+	}
+
+	for _, tc := range tcs {
+		_, err := Read(tc.input)
+		perr, ok := err.(*Error)
+		require.True(t, ok, "Not failed: %s", tc.input)
+		require.Equal(t, tc.line, perr.Line, "Wrong line: %s", tc.input)
+		require.Equal(t, tc.column, perr.Column, "Wrong column: %s", tc.input)
+		require.Equal(t, tc.expected, perr.Expected, "Wrong expectation: %s", tc.input)
+		require.Equal(t, tc.snippet, perr.Snippet, "Wrong snippet: %s", tc.input)
+	}
+}
+
+func TestSpans(t *testing.T) {
+	spans := make(Spans)
+	v, err := ReadSource("test.rad", "(do\n  (def x 'y)\n  [x \"ab\" #t])  ", spans)
+	require.NoError(t, err)
+
+	l := v.(*types.List).List()
+	def := l[1].(*types.List)
+	vec := l[2].(*types.Vector).Vector()
+
+	tcs := []struct {
+		v          types.Value
+		start, end Pos
+	}{
+		{v, Pos{0, 1, 1}, Pos{31, 3, 15}},
+		{l[0], Pos{1, 1, 2}, Pos{3, 1, 4}},
+		{def, Pos{6, 2, 3}, Pos{16, 2, 13}},
+		{def.List()[2], Pos{13, 2, 10}, Pos{15, 2, 12}},
+		{def.List()[2].(*types.List).List()[1], Pos{14, 2, 11}, Pos{15, 2, 12}},
+		{vec[1], Pos{22, 3, 6}, Pos{26, 3, 10}},
+		{vec[2], Pos{27, 3, 11}, Pos{29, 3, 13}},
+	}
+
+	for i, tc := range tcs {
+		sp, ok := spans.Span(tc.v)
+		require.True(t, ok, "No span(%d): %s", i, tc.v)
+		require.Equal(t, Span{"test.rad", tc.start, tc.end}, sp, "Wrong span(%d): %s", i, tc.v)
+	}
+
+	_, err = ReadSource("test.rad", "\n  (a", nil)
+	require.EqualError(t, err, `test.rad:2:5: expected value or ")" but found end of input`)
+}
+
+func TestProgram(t *testing.T) {
+	forms := values1
+	src := strings.Join(forms, "\n;; comment\n")
+
+	res, err := All(src)
+	require.NoError(t, err)
+	require.Equal(t, len(forms), len(res))
+	for i, str := range forms {
+		require.True(t, Expr(str).Equal(res[i]), "Not equal: %s", str)
+	}
+
+	res, err = Program(iotest.OneByteReader(strings.NewReader(src)))
+	require.NoError(t, err)
+	require.Equal(t, len(forms), len(res))
+	for i, str := range forms {
+		require.True(t, Expr(str).Equal(res[i]), "Not equal: %s", str)
+	}
+
+	res, err = All("  ;; nothing\n#| nothing |#\n")
+	require.NoError(t, err)
+	require.Empty(t, res)
+
+	_, err = All("(a b) (c))")
+	require.EqualError(t, err, `1:10: expected value but found ")"`)
+}
+
+func TestDecoder(t *testing.T) {
+	src := "abc 12\n(x\n  \"é\") [:k]\n  #t"
+	d := NewDecoder(iotest.OneByteReader(strings.NewReader(src)))
+	d.File = "test.rad"
+	d.Spans = make(Spans)
+
+	tcs := []struct {
+		str        string
+		start, end Pos
+	}{
+		{"abc", Pos{0, 1, 1}, Pos{3, 1, 4}},
+		{"12", Pos{4, 1, 5}, Pos{6, 1, 7}},
+		{`(x "é")`, Pos{7, 2, 1}, Pos{17, 3, 7}},
+		{"[:k]", Pos{18, 3, 8}, Pos{22, 3, 12}},
+		{"#t", Pos{25, 4, 3}, Pos{27, 4, 5}},
+	}
+
+	for _, tc := range tcs {
+		v, err := d.Decode()
+		require.NoError(t, err)
+		require.True(t, Expr(tc.str).Equal(v), "Not equal: %s, %s", tc.str, v)
+		sp, ok := d.Spans.Span(v)
+		require.True(t, ok, "No span: %s", tc.str)
+		require.Equal(t, Span{"test.rad", tc.start, tc.end}, sp, "Wrong span: %s", tc.str)
+	}
+
+	_, err := d.Decode()
+	require.Equal(t, io.EOF, err)
+
+	d = NewDecoder(iotest.OneByteReader(strings.NewReader("12.5 3/4 -0.25;;c\n1/2(x)")))
+	for _, str := range []string{"12.5", "3/4", "-0.25", "1/2", "(x)"} {
+		v, err := d.Decode()
+		require.NoError(t, err)
+		require.True(t, Expr(str).Equal(v), "Not equal: %s, %s", str, v)
+	}
+	_, err = d.Decode()
+	require.Equal(t, io.EOF, err)
+
+	d = NewDecoder(iotest.OneByteReader(strings.NewReader("(a)\n  (b c\n")))
+	_, err = d.Decode()
+	require.NoError(t, err)
+	_, err = d.Decode()
+	require.EqualError(t, err, `3:1: expected value or ")" but found end of input`)
+
+	// Values read in small pieces take linear time to decode,
+	// even with brackets in strings and comments.
+	src = "(" + strings.Repeat(`x "(" #| ) #| ( |# ) |# ;; )`+"\n", 10000) + ") abc"
+	d = NewDecoder(iotest.OneByteReader(strings.NewReader(src)))
+	v, err := d.Decode()
+	require.NoError(t, err)
+	require.Equal(t, 20000, v.(*types.List).Length())
+	v, err = d.Decode()
+	require.NoError(t, err)
+	require.True(t, Expr("abc").Equal(v))
+
+	d = NewDecoder(emptyReader{})
+	_, err = d.Decode()
+	require.Equal(t, io.ErrNoProgress, err)
+}
+
+// emptyReader never returns any data or error.
+type emptyReader struct{}
+
+func (emptyReader) Read([]byte) (int, error) {
+	return 0, nil
+}
+
 func BenchmarkParsing(b *testing.B) {
 	l := 0
 	for i := 0; i < b.N; i++ {
 		v := values[i%len(values)]
 		l += len(v)
-		Value(&ParserState{[]byte(v)})
+		Value(NewParserState([]byte(v)))
 	}
 
 	fmt.Printf("\naverage input length %d for size %d", l/b.N, b.N)
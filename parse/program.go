@@ -0,0 +1,276 @@
+package parse
+
+import (
+	"io"
+	"os"
+
+	"github.com/mossid/dr-alice/types"
+)
+
+// All parses every top-level value in str, in order.
+func All(str string) ([]types.Value, error) {
+	return newFullDecoder([]byte(str)).all()
+}
+
+// Program reads r to the end and parses every top-level value in it.
+func Program(r io.Reader) ([]types.Value, error) {
+	bz, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return newFullDecoder(bz).all()
+}
+
+// ProgramFile parses every top-level value in the named file. If spans is
+// not nil, it receives the span of every value parsed.
+func ProgramFile(filename string, spans Spans) ([]types.Value, error) {
+	bz, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	d := newFullDecoder(bz)
+	d.File = filename
+	d.Spans = spans
+	return d.all()
+}
+
+const (
+	minRead = 4096
+	// maxEmptyReads is the number of reads in a row that may return no
+	// data before Decode gives up with io.ErrNoProgress, as in bufio.
+	maxEmptyReads = 100
+)
+
+// Decoder reads top-level values one at a time from a stream, buffering
+// only as much input as the current value needs.
+type Decoder struct {
+	// File names the source in spans and errors.
+	File string
+	// Spans, if not nil, receives the span of every value parsed.
+	Spans Spans
+
+	r    io.Reader
+	buf  []byte
+	base Pos
+	eof  bool
+
+	// tried is the length of buf when parsing it last fell short, or 0.
+	// Parsing is only tried again once the input read since could end a
+	// value, or buf has doubled, so that reading a value in small pieces
+	// takes linear time.
+	tried   int
+	scan    scanner
+	scanned int
+	ended   bool
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r:    r,
+		base: Pos{0, 1, 1},
+	}
+}
+
+func newFullDecoder(bz []byte) *Decoder {
+	return &Decoder{
+		buf:  bz,
+		base: Pos{0, 1, 1},
+		eof:  true,
+	}
+}
+
+// Decode returns the next top-level value, or io.EOF once only whitespace
+// and comments remain.
+func (d *Decoder) Decode() (types.Value, error) {
+	for {
+		if !d.eof && !d.ready() {
+			if err := d.fill(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		st := NewParserState(d.buf)
+		st.File = d.File
+		st.base = d.base
+		if d.Spans != nil {
+			st.Spans = make(Spans)
+		}
+
+		spaceConsume(st)
+		if len(st.Stream) == 0 {
+			if d.eof {
+				d.advance(st)
+				return nil, io.EOF
+			}
+			d.retry()
+			continue
+		}
+
+		v, ok := datum(st).(types.Value)
+		if !d.eof && (!ok || !d.complete(st)) {
+			d.retry()
+			continue
+		}
+		if !ok {
+			return nil, st.Err()
+		}
+
+		for k, sp := range st.Spans {
+			d.Spans[k] = sp
+		}
+		d.advance(st)
+		return v, nil
+	}
+}
+
+// ready reports whether buf is worth parsing again.
+func (d *Decoder) ready() bool {
+	if d.tried == 0 {
+		return len(d.buf) != 0
+	}
+	for ; d.scanned < len(d.buf); d.scanned++ {
+		if d.scan.step(d.buf[d.scanned]) && d.scanned >= d.tried {
+			d.ended = true
+		}
+	}
+	return d.ended || len(d.buf) >= 2*d.tried
+}
+
+func (d *Decoder) retry() {
+	d.tried, d.ended = len(d.buf), false
+}
+
+// complete reports whether the value just parsed could not be extended by
+// more input, i.e. it ended with a closing delimiter or is followed by
+// whitespace or a delimiter. Anything else, like the "." after "12", may
+// still continue it.
+func (d *Decoder) complete(st *ParserState) bool {
+	switch d.buf[len(d.buf)-len(st.Stream)-1] {
+	case ')', ']', '}', '"':
+		return true
+	}
+	if len(st.Stream) == 0 {
+		return false
+	}
+	switch st.Stream[0] {
+	case ' ', '\n', '\r', '\t', '(', ')', '[', ']', '{', '}', '"', ';':
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *Decoder) advance(st *ParserState) {
+	d.base = st.pos(st.Offset())
+	d.buf = st.Stream
+	d.tried, d.scan, d.scanned, d.ended = 0, scanner{}, 0, false
+}
+
+// fill reads more input into the spare capacity of buf, growing it
+// geometrically.
+func (d *Decoder) fill() error {
+	if cap(d.buf)-len(d.buf) < minRead {
+		buf := make([]byte, len(d.buf), 2*cap(d.buf)+minRead)
+		copy(buf, d.buf)
+		d.buf = buf
+	}
+	for i := 0; i < maxEmptyReads; i++ {
+		n, err := d.r.Read(d.buf[len(d.buf):cap(d.buf)])
+		d.buf = d.buf[:len(d.buf)+n]
+		if err == io.EOF {
+			d.eof = true
+			return nil
+		}
+		if n > 0 || err != nil {
+			return err
+		}
+	}
+	return io.ErrNoProgress
+}
+
+// scanner follows the brackets, strings and comments of the input just
+// enough to tell where a top-level value may end.
+type scanner struct {
+	depth int  // open brackets
+	atom  bool // in a top-level atom
+	str   bool // in a string
+	esc   bool // after a backslash in a string
+	line  bool // in a line comment
+	block int  // open block comments
+	prev  byte
+}
+
+// step advances over c and reports whether a top-level value may end with
+// it.
+func (sc *scanner) step(c byte) (end bool) {
+	prev := sc.prev
+	sc.prev = c
+	switch {
+	case sc.line:
+		sc.line = c != '\n'
+	case sc.block > 0:
+		if prev == '|' && c == '#' {
+			sc.block--
+			sc.prev = 0
+		} else if prev == '#' && c == '|' {
+			sc.block++
+			sc.prev = 0
+		}
+	case sc.str:
+		switch {
+		case sc.esc:
+			sc.esc = false
+		case c == '\\':
+			sc.esc = true
+		case c == '"':
+			sc.str = false
+			return sc.depth == 0
+		}
+	default:
+		switch c {
+		case '(', '[', '{':
+			end = sc.atom
+			sc.atom = false
+			sc.depth++
+		case ')', ']', '}':
+			if sc.depth > 0 {
+				sc.depth--
+			}
+			sc.atom = false
+			return sc.depth == 0
+		case '"':
+			end = sc.atom
+			sc.atom = false
+			sc.str = true
+		case ' ', '\n', '\r', '\t', ';':
+			end = sc.atom
+			sc.atom = false
+			sc.line = c == ';' && prev == ';'
+		case '|':
+			if prev == '#' {
+				sc.atom = false
+				sc.block++
+				sc.prev = 0
+				return false
+			}
+			sc.atom = sc.depth == 0
+		default:
+			sc.atom = sc.depth == 0
+		}
+	}
+	return end
+}
+
+func (d *Decoder) all() (res []types.Value, err error) {
+	for {
+		v, err := d.Decode()
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+}
@@ -0,0 +1,83 @@
+package parse
+
+import (
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/mossid/dr-alice/types"
+)
+
+// Pos is a position in the source.
+type Pos struct {
+	Offset int // bytes from the start of the input
+	Line   int // 1-based
+	Column int // 1-based, counted in runes
+}
+
+// Span is the range of source a value was read from.
+type Span struct {
+	File  string
+	Start Pos
+	End   Pos
+}
+
+func (sp Span) String() string {
+	res := strconv.Itoa(sp.Start.Line) + ":" + strconv.Itoa(sp.Start.Column)
+	if sp.File != "" {
+		res = sp.File + ":" + res
+	}
+	return res
+}
+
+// Spans maps parsed values to their spans. Values are keyed by pointer
+// identity, so only the exact values returned by the parser are found.
+type Spans map[types.Value]Span
+
+func (sps Spans) Span(v types.Value) (sp Span, ok bool) {
+	if sps == nil || isEmptyList(v) {
+		return
+	}
+	sp, ok = sps[v]
+	return
+}
+
+// All empty lists are the same nil pointer, so they cannot be told apart.
+func isEmptyList(v types.Value) bool {
+	l, ok := v.(*types.List)
+	return ok && l == nil
+}
+
+func (st *ParserState) record(v types.Value, start int) {
+	if st.Spans == nil || isEmptyList(v) {
+		return
+	}
+	st.Spans[v] = Span{st.File, st.pos(start), st.pos(st.Offset())}
+}
+
+func (st *ParserState) pos(off int) Pos {
+	if st.lines == nil {
+		st.lines = lineStarts(st.src)
+	}
+	line := sort.SearchInts(st.lines, off+1) - 1
+	col := utf8.RuneCount(st.src[st.lines[line]:off]) + 1
+	res := Pos{off, line + 1, col}
+	if st.base.Line > 0 {
+		if line == 0 {
+			res.Column += st.base.Column - 1
+		}
+		res.Line += st.base.Line - 1
+		res.Offset += st.base.Offset
+	}
+	return res
+}
+
+func lineStarts(src []byte) []int {
+	res := []int{0}
+	for i, b := range src {
+		if b == '\n' {
+			res = append(res, i+1)
+		}
+	}
+	return res
+}
@@ -0,0 +1,318 @@
+package types
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Encode returns the canonical binary encoding of v. Structurally equal
+// values have the same encoding regardless of pointer identity or Go map
+// order, so it can be used to key maps by value.
+func Encode(v Value) []byte {
+	var buf bytes.Buffer
+	newEncoder().encode(&buf, v)
+	return buf.Bytes()
+}
+
+// Hash returns the SHA-256 digest of the canonical encoding of v. Envs
+// hash as the root of a hash tree over their visible bindings instead,
+// which immutable envs share with the envs they were derived from. Values
+// holding mutable envs or states hash as their current contents.
+func Hash(v Value) digest {
+	if env, ok := v.(Env); ok {
+		return newEncoder().envHash(env)
+	}
+	return sha256.Sum256(Encode(v))
+}
+
+type digest = [sha256.Size]byte
+
+// hashEqual reports whether a and b have the same type and hash.
+func hashEqual(a, b Value) bool {
+	return b != nil && a.Type() == b.Type() && Hash(a) == Hash(b)
+}
+
+// encoder encodes the env of a closure by its hash rather than inline.
+// Closures defined one after another each capture the env holding the
+// previous ones, so inlining would encode the first of them an exponential
+// number of times. The encoder remembers the hash of every env it
+// encodes, and immutable envs keep theirs, so each is hashed once.
+//
+// Mutable envs and the refs of states can change after they are hashed,
+// so no hash of anything holding one is kept beyond the encoder.
+type encoder struct {
+	envs map[Env]envDigest
+
+	// mutable is set once a mutable env or a state has been encoded.
+	mutable bool
+}
+
+type envDigest struct {
+	h       digest
+	mutable bool
+}
+
+func newEncoder() *encoder {
+	return &encoder{envs: make(map[Env]envDigest)}
+}
+
+// tracked calls f and reports whether it encoded anything mutable, which
+// then also counts for the value being encoded around it.
+func (e *encoder) tracked(f func()) bool {
+	outer := e.mutable
+	e.mutable = false
+	f()
+	res := e.mutable
+	e.mutable = outer || res
+	return res
+}
+
+// envHash hashes the trie of the visible bindings of env. The shape of a
+// trie only depends on the names in it, so equal envs hash alike however
+// they are represented. The hashes of trie nodes and bindings are kept in
+// them, so hashing an env derived from a hashed one only hashes the new
+// path of the trie.
+func (e *encoder) envHash(env Env) digest {
+	if d, ok := e.envs[env]; ok {
+		e.mutable = e.mutable || d.mutable
+		return d.h
+	}
+	var cache *atomic.Pointer[digest]
+	switch env := env.(type) {
+	case *hamtEnv:
+		cache = &env.hash
+	case *listEnv:
+		cache = &env.hash
+	}
+	if cache != nil {
+		if h := cache.Load(); h != nil {
+			return *h
+		}
+	}
+
+	var root digest
+	mutable := e.tracked(func() { root = e.nodeHash(envTrie(env)) })
+	mutable = mutable || !env.IsImmutable()
+	e.mutable = e.mutable || mutable
+	h := sha256.Sum256(append([]byte{byte(TypeEnv)}, root[:]...))
+	e.envs[env] = envDigest{h, mutable}
+	if cache != nil && !mutable {
+		cache.Store(&h)
+	}
+	return h
+}
+
+func (e *encoder) nodeHash(n *hnode) digest {
+	if n == nil {
+		return sha256.Sum256(nil)
+	}
+	if h := n.hash.Load(); h != nil {
+		return *h
+	}
+
+	hasher := sha256.New()
+	var bz [1 + sha256.Size]byte
+	binary.BigEndian.PutUint32(bz[:4], n.bitmap)
+	hasher.Write(bz[:4])
+	slots := n.slots
+	if n.bitmap == 0 {
+		// collisions are kept in insertion order
+		slots = n.copySlots()
+		sort.Slice(slots, func(i, j int) bool { return slots[i].key < slots[j].key })
+	}
+	mutable := e.tracked(func() {
+		for _, s := range slots {
+			var h digest
+			if s.sub != nil {
+				bz[0] = 1
+				h = e.nodeHash(s.sub)
+			} else {
+				bz[0] = 0
+				h = e.slotHash(s)
+			}
+			copy(bz[1:], h[:])
+			hasher.Write(bz[:])
+		}
+	})
+	var h digest
+	hasher.Sum(h[:0])
+	if !mutable {
+		n.hash.Store(&h)
+	}
+	return h
+}
+
+func (e *encoder) slotHash(s *hslot) digest {
+	if h := s.sum.Load(); h != nil {
+		return *h
+	}
+	var buf bytes.Buffer
+	encodeString(&buf, s.key)
+	mutable := e.tracked(func() { e.encode(&buf, s.e.v) })
+	h := sha256.Sum256(buf.Bytes())
+	if !mutable {
+		s.sum.Store(&h)
+	}
+	return h
+}
+
+func (e *encoder) encode(buf *bytes.Buffer, v Value) {
+	if v == nil {
+		buf.WriteByte(byte(TypeNULL))
+		return
+	}
+
+	buf.WriteByte(byte(v.Type()))
+	switch v := v.(type) {
+	case *Atom:
+		encodeString(buf, v.Ident())
+	case *Keyword:
+		encodeString(buf, v.Ident())
+	case *String:
+		encodeString(buf, v.Str())
+	case *PrimFn:
+		encodeString(buf, v.Ident())
+	case *Num:
+		encodeString(buf, v.rat.RatString())
+	case *Bool:
+		if v.Bool() {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case *List:
+		e.encodeValues(buf, v.List())
+	case *Vector:
+		e.encodeValues(buf, v.Vector())
+	case *Dict:
+		entries := v.entries()
+		encodeUint(buf, uint64(len(entries)))
+		for _, en := range entries {
+			e.encode(buf, en.k)
+			e.encode(buf, en.v)
+		}
+	case *Ref:
+		encodeUint(buf, v.Uint())
+	case *Lambda:
+		e.encodeLambda(buf, v)
+	case *LambdaRec:
+		encodeString(buf, v.Self)
+		e.encodeLambda(buf, v.Lambda)
+	case Env:
+		e.mutable = e.mutable || !v.IsImmutable()
+		e.encodeEnv(buf, v)
+	case *State:
+		e.mutable = true
+		e.encode(buf, v.Env)
+		encodeUint(buf, v.Refs.next)
+		ixs := v.Refs.keys()
+		encodeUint(buf, uint64(len(ixs)))
+		for _, ix := range ixs {
+			encodeUint(buf, ix)
+			e.encode(buf, v.Refs.m[ix])
+		}
+	default:
+		panic("unknown value type")
+	}
+}
+
+func encodeUint(buf *bytes.Buffer, u uint64) {
+	var bz [binary.MaxVarintLen64]byte
+	buf.Write(bz[:binary.PutUvarint(bz[:], u)])
+}
+
+func encodeString(buf *bytes.Buffer, str string) {
+	encodeUint(buf, uint64(len(str)))
+	buf.WriteString(str)
+}
+
+func (e *encoder) encodeValues(buf *bytes.Buffer, vs []Value) {
+	encodeUint(buf, uint64(len(vs)))
+	for _, v := range vs {
+		e.encode(buf, v)
+	}
+}
+
+func (e *encoder) encodeLambda(buf *bytes.Buffer, l *Lambda) {
+	encodeUint(buf, uint64(len(l.Args)))
+	for _, arg := range l.Args {
+		encodeString(buf, arg)
+	}
+	e.encodeValues(buf, l.Bodies)
+	if l.Env == nil {
+		buf.WriteByte(byte(TypeNULL))
+		return
+	}
+	buf.WriteByte(byte(TypeEnv))
+	h := e.envHash(l.Env)
+	buf.Write(h[:])
+}
+
+// Envs are encoded as their visible bindings sorted by name, so shadowed
+// bindings and the order of definition do not matter.
+func (e *encoder) encodeEnv(buf *bytes.Buffer, env Env) {
+	names, m := bindings(env)
+	encodeUint(buf, uint64(len(names)))
+	for _, name := range names {
+		encodeString(buf, name)
+		e.encode(buf, m[name])
+	}
+}
+
+// Compare orders values first by type, then numbers numerically, strings,
+// atoms and keywords lexically, lists and vectors element by element, and
+// anything else by canonical encoding. It returns 0 exactly when the
+// encodings of a and b are equal.
+func Compare(a, b Value) int {
+	ta, tb := typeOf(a), typeOf(b)
+	if ta != tb {
+		if ta < tb {
+			return -1
+		}
+		return 1
+	}
+
+	switch a := a.(type) {
+	case *Num:
+		return a.Cmp(b.(*Num))
+	case *String:
+		return strings.Compare(a.Str(), b.(*String).Str())
+	case *Atom:
+		return strings.Compare(a.Ident(), b.(*Atom).Ident())
+	case *Keyword:
+		return strings.Compare(a.Ident(), b.(*Keyword).Ident())
+	case *List:
+		return compareValues(a.List(), b.(*List).List())
+	case *Vector:
+		return compareValues(a.Vector(), b.(*Vector).Vector())
+	default:
+		return bytes.Compare(Encode(a), Encode(b))
+	}
+}
+
+func typeOf(v Value) ValueType {
+	if v == nil {
+		return TypeNULL
+	}
+	return v.Type()
+}
+
+func compareValues(as, bs []Value) int {
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := Compare(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	default:
+		return 0
+	}
+}
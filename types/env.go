@@ -1,7 +1,9 @@
 package types
 
 import (
+	"sort"
 	"strings"
+	"sync/atomic"
 
 	"github.com/mossid/dr-alice/types/proto"
 )
@@ -10,11 +12,16 @@ type Env interface {
 	Value
 	Set(name Ident, value Value) Env
 	Get(name Ident) (Value, bool)
+	// Iterate calls f on every visible binding in name order until it
+	// returns true. Shadowed bindings are skipped.
+	Iterate(f func(name Ident, value Value) bool)
 	CloneMutable() Env
 	CloneImmutable() Env
 	IsImmutable() bool
 }
 
+// listEnv is a linked list of bindings, newest first. Lookup is linear in
+// the number of definitions made, including shadowed ones.
 type node struct {
 	name  Ident
 	value Value
@@ -23,6 +30,10 @@ type node struct {
 
 type listEnv struct {
 	top *node
+
+	// hash caches the hash of the env once computed, unless it holds a
+	// mutable value. See envHash.
+	hash atomic.Pointer[digest]
 }
 
 var _ Env = (*listEnv)(nil)
@@ -46,6 +57,10 @@ func (env *listEnv) Get(name Ident) (Value, bool) {
 	return nil, false
 }
 
+func (env *listEnv) Iterate(f func(Ident, Value) bool) {
+	iterateEnv(env, f)
+}
+
 func (env *listEnv) CloneImmutable() Env {
 	return env
 }
@@ -53,7 +68,9 @@ func (env *listEnv) CloneImmutable() Env {
 func (env *listEnv) CloneMutable() Env {
 	res := &mapEnv{make(map[Ident]Value)}
 	for ptr := env.top; ptr != nil; ptr = ptr.next {
-		res.m[ptr.name] = ptr.value
+		if _, ok := res.m[ptr.name]; !ok {
+			res.m[ptr.name] = ptr.value
+		}
 	}
 	return res
 }
@@ -63,11 +80,11 @@ func (env *listEnv) IsImmutable() bool {
 }
 
 func (env *listEnv) Equal(v Value) bool {
-	panic("not implemented")
+	return hashEqual(env, v)
 }
 
 func (env *listEnv) Proto() *proto.Value {
-	panic("not implemented")
+	return toProto(env)
 }
 
 func (env *listEnv) Type() ValueType {
@@ -75,11 +92,7 @@ func (env *listEnv) Type() ValueType {
 }
 
 func (env *listEnv) String() string {
-	var pairs []string
-	for ptr := env.top; ptr != nil; ptr = ptr.next {
-		pairs = append(pairs, ptr.name+" => "+ptr.value.String())
-	}
-	return "{" + strings.Join(pairs, ", ") + "}"
+	return envString(env)
 }
 
 type mapEnv struct {
@@ -98,10 +111,14 @@ func (env *mapEnv) Get(name Ident) (res Value, ok bool) {
 	return
 }
 
+func (env *mapEnv) Iterate(f func(Ident, Value) bool) {
+	iterateEnv(env, f)
+}
+
 func (env *mapEnv) CloneImmutable() Env {
-	res := &listEnv{}
+	res := NewEnv()
 	for k, v := range env.m {
-		res.top = &node{k, v, res.top}
+		res = res.Set(k, v)
 	}
 	return res
 }
@@ -119,16 +136,70 @@ func (env *mapEnv) IsImmutable() bool {
 }
 
 func (env *mapEnv) String() string {
-	return (*env).String()
+	return envString(env)
 }
 
 func (env *mapEnv) Equal(v Value) bool {
-	panic("not implemented")
+	return hashEqual(env, v)
 }
 
 func (env *mapEnv) Proto() *proto.Value {
-	panic("not implemented")
+	return toProto(env)
 }
 func (env *mapEnv) Type() ValueType {
 	return TypeEnv
 }
+
+// bindings returns the visible bindings of env and their names in sorted
+// order. Shadowed bindings are left out.
+func bindings(env Env) ([]Ident, map[Ident]Value) {
+	m := make(map[Ident]Value)
+	switch env := env.(type) {
+	case *listEnv:
+		for ptr := env.top; ptr != nil; ptr = ptr.next {
+			if _, ok := m[ptr.name]; !ok {
+				m[ptr.name] = ptr.value
+			}
+		}
+	case *mapEnv:
+		m = env.m
+	case *hamtEnv:
+		for ptr := env.top; ptr != nil; ptr = ptr.next {
+			if _, ok := m[ptr.name]; !ok {
+				m[ptr.name] = ptr.value
+			}
+		}
+		env.root.iterate(func(name string, e dictEntry) {
+			if _, ok := m[name]; !ok {
+				m[name] = e.v
+			}
+		})
+	default:
+		panic("unknown env type")
+	}
+
+	names := make([]Ident, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, m
+}
+
+func iterateEnv(env Env, f func(Ident, Value) bool) {
+	names, m := bindings(env)
+	for _, name := range names {
+		if f(name, m[name]) {
+			return
+		}
+	}
+}
+
+func envString(env Env) string {
+	names, m := bindings(env)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + " => " + m[name].String()
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
@@ -0,0 +1,100 @@
+package types
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var envs = []struct {
+	Name string
+	New  func() Env
+}{
+	{"listEnv", NewListEnv},
+	{"hamtEnv", NewEnv},
+	{"mapEnv", func() Env { return NewListEnv().CloneMutable() }},
+}
+
+func TestEnv(t *testing.T) {
+	for _, tc := range envs {
+		env := tc.New()
+		_, ok := env.Get("a")
+		require.False(t, ok, tc.Name)
+
+		env = env.Set("a", NewNum(1)).Set("b", NewNum(2))
+		env0 := env.CloneImmutable()
+		env = env.Set("a", NewNum(3))
+		v, ok := env.Get("a")
+		require.True(t, ok, tc.Name)
+		require.True(t, NewNum(3).Equal(v), tc.Name)
+		require.Equal(t, "{a => 3, b => 2}", env.String(), tc.Name)
+
+		v, _ = env0.Get("a")
+		require.True(t, NewNum(1).Equal(v), "%s: immutable clone changed", tc.Name)
+		require.True(t, env0.IsImmutable(), tc.Name)
+		require.False(t, env.CloneMutable().IsImmutable(), tc.Name)
+
+		require.True(t, env.Equal(env.CloneMutable()), tc.Name)
+		require.True(t, env.Equal(env.CloneImmutable()), tc.Name)
+		require.True(t, env.Equal(NewEnv().Set("b", NewNum(2)).Set("a", NewNum(3))), tc.Name)
+	}
+}
+
+func TestEnvIterate(t *testing.T) {
+	for _, tc := range envs {
+		env := tc.New().Set("b", NewNum(1)).Set("a", NewNum(2)).Set("c", NewNum(3)).Set("b", NewNum(4))
+		var names []Ident
+		var vals []Value
+		env.Iterate(func(name Ident, v Value) bool {
+			names = append(names, name)
+			vals = append(vals, v)
+			return false
+		})
+		require.Equal(t, []Ident{"a", "b", "c"}, names, tc.Name)
+		require.True(t, NewVector(NewNum(2), NewNum(4), NewNum(3)).Equal(NewVector(vals...)), tc.Name)
+
+		n := 0
+		env.Iterate(func(Ident, Value) bool {
+			n++
+			return n == 2
+		})
+		require.Equal(t, 2, n, tc.Name)
+	}
+}
+
+func TestHAMTEnvLarge(t *testing.T) {
+	env := NewEnv()
+	for i := 0; i < 10000; i++ {
+		env = env.Set("x"+strconv.Itoa(i%5000), NewNum(int64(i)))
+	}
+	for i := 0; i < 5000; i++ {
+		v, ok := env.Get("x" + strconv.Itoa(i))
+		require.True(t, ok)
+		require.True(t, NewNum(int64(i+5000)).Equal(v))
+	}
+	names, _ := bindings(env)
+	require.Len(t, names, 5000)
+}
+
+// BenchmarkEnvDeepRecursion binds a fresh argument at each of 1000 nested
+// calls and looks up a global defined before them, as a recursive
+// function does with a large prelude.
+func BenchmarkEnvDeepRecursion(b *testing.B) {
+	for _, tc := range envs[:2] {
+		b.Run(tc.Name, func(b *testing.B) {
+			prelude := tc.New().Set("global", NewNum(0))
+			for i := 0; i < 200; i++ {
+				prelude = prelude.Set("def"+strconv.Itoa(i), NewNum(int64(i)))
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				env := prelude
+				for depth := 0; depth < 1000; depth++ {
+					env = env.Set("n", NewNum(int64(depth)))
+					env.Get("global")
+				}
+			}
+		})
+	}
+}
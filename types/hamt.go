@@ -0,0 +1,190 @@
+package types
+
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+// hnode is a node of a persistent hash array mapped trie keyed by strings:
+// canonical encodings in dicts and names in envs. Each level consumes 5
+// bits of the key hash. Once the hash is used up, the remaining keys
+// collide and are kept in a plain list. Nodes are never modified after
+// construction; updates copy the path from the root, so old versions stay
+// valid.
+type hnode struct {
+	bitmap uint32
+	slots  []*hslot
+
+	// hash caches the hash of an env trie. See envHash.
+	hash atomic.Pointer[digest]
+}
+
+// hslot holds either an entry or, if sub is not nil, a subtree.
+type hslot struct {
+	hash uint64
+	key  string
+	e    dictEntry
+	sub  *hnode
+
+	// sum caches the hash of an env binding. See envHash.
+	sum atomic.Pointer[digest]
+}
+
+const (
+	hbits = 5
+	hmask = 1<<hbits - 1
+)
+
+// hashKey returns the 64 bit FNV-1a hash of ek.
+func hashKey(ek string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(ek); i++ {
+		h ^= uint64(ek[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+func (n *hnode) index(h uint64, shift uint) (bit uint32, ix int) {
+	bit = 1 << ((h >> shift) & hmask)
+	return bit, bits.OnesCount32(n.bitmap & (bit - 1))
+}
+
+func (n *hnode) get(h uint64, shift uint, ek string) (dictEntry, bool) {
+	for n != nil {
+		if shift >= 64 {
+			for _, s := range n.slots {
+				if s.key == ek {
+					return s.e, true
+				}
+			}
+			return dictEntry{}, false
+		}
+		bit, ix := n.index(h, shift)
+		if n.bitmap&bit == 0 {
+			return dictEntry{}, false
+		}
+		s := n.slots[ix]
+		if s.sub == nil {
+			return s.e, s.key == ek
+		}
+		n, shift = s.sub, shift+hbits
+	}
+	return dictEntry{}, false
+}
+
+// insert returns n with ek set to e, and whether ek was not in n before.
+func (n *hnode) insert(h uint64, shift uint, ek string, e dictEntry) (*hnode, bool) {
+	slot := &hslot{hash: h, key: ek, e: e}
+	if n == nil {
+		n = &hnode{}
+	}
+
+	if shift >= 64 {
+		for i, s := range n.slots {
+			if s.key == ek {
+				return n.replace(i, slot), false
+			}
+		}
+		return &hnode{slots: append(n.copySlots(), slot)}, true
+	}
+
+	bit, ix := n.index(h, shift)
+	if n.bitmap&bit == 0 {
+		slots := make([]*hslot, len(n.slots)+1)
+		copy(slots, n.slots[:ix])
+		slots[ix] = slot
+		copy(slots[ix+1:], n.slots[ix:])
+		return &hnode{bitmap: n.bitmap | bit, slots: slots}, true
+	}
+
+	s := n.slots[ix]
+	switch {
+	case s.sub != nil:
+		sub, added := s.sub.insert(h, shift+hbits, ek, e)
+		return n.replace(ix, &hslot{sub: sub}), added
+	case s.key == ek:
+		return n.replace(ix, slot), false
+	default:
+		sub, _ := (*hnode)(nil).insert(s.hash, shift+hbits, s.key, s.e)
+		sub, _ = sub.insert(h, shift+hbits, ek, e)
+		return n.replace(ix, &hslot{sub: sub}), true
+	}
+}
+
+// remove returns n without ek, and whether ek was in n. Empty nodes
+// become nil and subtrees left with a single entry are pulled up.
+func (n *hnode) remove(h uint64, shift uint, ek string) (*hnode, bool) {
+	if n == nil {
+		return nil, false
+	}
+
+	if shift >= 64 {
+		for i, s := range n.slots {
+			if s.key == ek {
+				return n.without(i, 0), true
+			}
+		}
+		return n, false
+	}
+
+	bit, ix := n.index(h, shift)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+
+	s := n.slots[ix]
+	if s.sub == nil {
+		if s.key != ek {
+			return n, false
+		}
+		return n.without(ix, bit), true
+	}
+
+	sub, removed := s.sub.remove(h, shift+hbits, ek)
+	switch {
+	case !removed:
+		return n, false
+	case sub == nil:
+		return n.without(ix, bit), true
+	case len(sub.slots) == 1 && sub.slots[0].sub == nil:
+		return n.replace(ix, sub.slots[0]), true
+	default:
+		return n.replace(ix, &hslot{sub: sub}), true
+	}
+}
+
+func (n *hnode) copySlots() []*hslot {
+	res := make([]*hslot, len(n.slots), len(n.slots)+1)
+	copy(res, n.slots)
+	return res
+}
+
+func (n *hnode) replace(ix int, s *hslot) *hnode {
+	slots := n.copySlots()
+	slots[ix] = s
+	return &hnode{bitmap: n.bitmap, slots: slots}
+}
+
+func (n *hnode) without(ix int, bit uint32) *hnode {
+	if len(n.slots) == 1 {
+		return nil
+	}
+	slots := make([]*hslot, 0, len(n.slots)-1)
+	slots = append(slots, n.slots[:ix]...)
+	slots = append(slots, n.slots[ix+1:]...)
+	return &hnode{bitmap: n.bitmap &^ bit, slots: slots}
+}
+
+func (n *hnode) iterate(f func(ek string, e dictEntry)) {
+	if n == nil {
+		return
+	}
+	for _, s := range n.slots {
+		if s.sub != nil {
+			s.sub.iterate(f)
+		} else {
+			f(s.key, s.e)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package types
+
+import (
+	"sync/atomic"
+
+	"github.com/mossid/dr-alice/types/proto"
+)
+
+// hamtEnv is a persistent env backed by a hash array mapped trie keyed by
+// name, with the most recent bindings kept in a short list in front of it.
+// Binding function arguments on top of a closure env is then a single
+// allocation, and once the list is full it is merged into the trie, so
+// lookup takes time logarithmic in the number of visible bindings.
+// Shadowed bindings are dropped when merged rather than kept around.
+type hamtEnv struct {
+	root  *hnode
+	top   *node
+	depth int
+
+	// hash caches the hash of the env once computed, unless it holds a
+	// mutable value. See envHash.
+	hash atomic.Pointer[digest]
+}
+
+// frameSize is the number of bindings kept in front of the trie.
+const frameSize = 8
+
+var _ Env = (*hamtEnv)(nil)
+
+// NewEnv returns an empty immutable env.
+func NewEnv() Env {
+	return &hamtEnv{}
+}
+
+func (env *hamtEnv) Set(name Ident, value Value) Env {
+	top := &node{name, value, env.top}
+	if env.depth < frameSize {
+		return &hamtEnv{root: env.root, top: top, depth: env.depth + 1}
+	}
+	return &hamtEnv{root: mergeFrame(env.root, top)}
+}
+
+// mergeFrame returns root with the bindings from top on inserted, oldest
+// first so that newer ones shadow them.
+func mergeFrame(root *hnode, top *node) *hnode {
+	frame := make([]*node, 0, frameSize+1)
+	for ptr := top; ptr != nil; ptr = ptr.next {
+		frame = append(frame, ptr)
+	}
+	for i := len(frame) - 1; i >= 0; i-- {
+		root, _ = root.insert(hashKey(frame[i].name), 0, frame[i].name, dictEntry{v: frame[i].value})
+	}
+	return root
+}
+
+// envTrie returns a trie of the visible bindings of env.
+func envTrie(env Env) *hnode {
+	switch env := env.(type) {
+	case *hamtEnv:
+		return mergeFrame(env.root, env.top)
+	case *listEnv:
+		return mergeFrame(nil, env.top)
+	default:
+		var root *hnode
+		env.Iterate(func(name Ident, v Value) bool {
+			root, _ = root.insert(hashKey(name), 0, name, dictEntry{v: v})
+			return false
+		})
+		return root
+	}
+}
+
+func (env *hamtEnv) Get(name Ident) (Value, bool) {
+	for ptr := env.top; ptr != nil; ptr = ptr.next {
+		if ptr.name == name {
+			return ptr.value, true
+		}
+	}
+	e, ok := env.root.get(hashKey(name), 0, name)
+	return e.v, ok
+}
+
+func (env *hamtEnv) Iterate(f func(Ident, Value) bool) {
+	iterateEnv(env, f)
+}
+
+func (env *hamtEnv) CloneImmutable() Env {
+	return env
+}
+
+func (env *hamtEnv) CloneMutable() Env {
+	_, m := bindings(env)
+	return &mapEnv{m}
+}
+
+func (env *hamtEnv) IsImmutable() bool {
+	return true
+}
+
+func (env *hamtEnv) Equal(v Value) bool {
+	return hashEqual(env, v)
+}
+
+func (env *hamtEnv) Proto() *proto.Value {
+	return toProto(env)
+}
+
+func (env *hamtEnv) Type() ValueType {
+	return TypeEnv
+}
+
+func (env *hamtEnv) String() string {
+	return envString(env)
+}
@@ -1,5 +1,7 @@
 package types
 
+import "sort"
+
 type Intmap struct {
 	m    map[uint64]Value
 	next uint64
@@ -27,3 +29,28 @@ func (m *Intmap) Get(ix uint64) (res Value, ok bool) {
 func (m *Intmap) Set(ix uint64, v Value) {
 	m.m[ix] = v
 }
+
+// Remove deletes ix from m. If ix is the last index inserted, the next
+// insert reuses it, so removing inserted indices in reverse order undoes
+// the inserts.
+func (m *Intmap) Remove(ix uint64) {
+	delete(m.m, ix)
+	if ix+1 == m.next {
+		m.next = ix
+	}
+}
+
+// Len returns the number of values in m.
+func (m *Intmap) Len() int {
+	return len(m.m)
+}
+
+// keys returns the indices in use in ascending order.
+func (m *Intmap) keys() []uint64 {
+	res := make([]uint64, 0, len(m.m))
+	for ix := range m.m {
+		res = append(res, ix)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
@@ -0,0 +1,265 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ToJSON encodes a data value as JSON. Values map as follows:
+//
+//	string            "abc"
+//	boolean           true, false
+//	number            1, -0.25 if it has a finite decimal expansion,
+//	                  otherwise {"$rat": "1/3"}
+//	vector            [1, 2]
+//	list              {"$list": [1, 2]}
+//	keyword           {"$keyword": "k"}
+//	atom              {"$atom": "x"}
+//	dict              {"k": 1} if every key is a string,
+//	                  otherwise {"$dict": [[key, value], ...]}
+//
+// An object with a single key starting with "$" is always one of the
+// tagged forms above, so a string-keyed dict that would look like one is
+// encoded with "$dict" instead. Dict entries are written in key order.
+// Strings must be valid UTF-8 to survive the round trip.
+//
+// Functions, refs, envs and states are not data and cannot be encoded.
+func ToJSON(v Value) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeJSON(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeJSON(buf *bytes.Buffer, v Value) error {
+	switch v := v.(type) {
+	case *String:
+		writeJSONString(buf, v.Str())
+	case *Bool:
+		if v.Bool() {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case *Num:
+		if _, ok := decimalPlaces(v.rat.Denom()); ok {
+			buf.WriteString(v.String())
+		} else {
+			writeJSONTagged(buf, "$rat")
+			writeJSONString(buf, v.rat.String())
+			buf.WriteByte('}')
+		}
+	case *Vector:
+		return encodeJSONArray(buf, v.Vector())
+	case *List:
+		writeJSONTagged(buf, "$list")
+		if err := encodeJSONArray(buf, v.List()); err != nil {
+			return err
+		}
+		buf.WriteByte('}')
+	case *Keyword:
+		writeJSONTagged(buf, "$keyword")
+		writeJSONString(buf, v.Ident())
+		buf.WriteByte('}')
+	case *Atom:
+		writeJSONTagged(buf, "$atom")
+		writeJSONString(buf, v.Ident())
+		buf.WriteByte('}')
+	case *Dict:
+		return encodeJSONDict(buf, v)
+	default:
+		return fmt.Errorf("cannot encode %s as JSON", v)
+	}
+	return nil
+}
+
+func writeJSONString(buf *bytes.Buffer, str string) {
+	bz, _ := json.Marshal(str)
+	buf.Write(bz)
+}
+
+func writeJSONTagged(buf *bytes.Buffer, tag string) {
+	buf.WriteByte('{')
+	writeJSONString(buf, tag)
+	buf.WriteByte(':')
+}
+
+func encodeJSONArray(buf *bytes.Buffer, vs []Value) error {
+	buf.WriteByte('[')
+	for i, v := range vs {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := encodeJSON(buf, v); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(']')
+	return nil
+}
+
+func encodeJSONDict(buf *bytes.Buffer, d *Dict) error {
+	entries := d.entries()
+	object := true
+	for _, e := range entries {
+		if _, ok := e.k.(*String); !ok {
+			object = false
+		}
+	}
+	if len(entries) == 1 && object && isJSONTag(entries[0].k.(*String).Str()) {
+		object = false
+	}
+
+	if !object {
+		writeJSONTagged(buf, "$dict")
+		buf.WriteByte('[')
+		for i, e := range entries {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeJSONArray(buf, []Value{e.k, e.v}); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("]}")
+		return nil
+	}
+
+	buf.WriteByte('{')
+	for i, e := range entries {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeJSONString(buf, e.k.(*String).Str())
+		buf.WriteByte(':')
+		if err := encodeJSON(buf, e.v); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+func isJSONTag(key string) bool {
+	return strings.HasPrefix(key, "$")
+}
+
+// FromJSON decodes a single JSON value using the mapping described at
+// ToJSON. JSON null has no radicle counterpart and is rejected.
+func FromJSON(bz []byte) (Value, error) {
+	dec := json.NewDecoder(bytes.NewReader(bz))
+	dec.UseNumber()
+	var x interface{}
+	if err := dec.Decode(&x); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+	return fromJSON(x)
+}
+
+func fromJSON(x interface{}) (Value, error) {
+	switch x := x.(type) {
+	case string:
+		return NewString(x), nil
+	case bool:
+		return NewBool(x), nil
+	case json.Number:
+		n, ok := ParseNum(x.String())
+		if !ok {
+			return nil, fmt.Errorf("invalid number %s", x)
+		}
+		return n, nil
+	case []interface{}:
+		vs, err := fromJSONArray(x)
+		if err != nil {
+			return nil, err
+		}
+		return NewVector(vs...), nil
+	case map[string]interface{}:
+		if len(x) == 1 {
+			for k, y := range x {
+				if isJSONTag(k) {
+					return fromJSONTagged(k, y)
+				}
+			}
+		}
+		res := NewDict()
+		for k, y := range x {
+			v, err := fromJSON(y)
+			if err != nil {
+				return nil, err
+			}
+			res.Set(NewString(k), v)
+		}
+		return res, nil
+	default:
+		return nil, fmt.Errorf("cannot decode JSON %v", x)
+	}
+}
+
+func fromJSONArray(xs []interface{}) ([]Value, error) {
+	res := make([]Value, len(xs))
+	for i, x := range xs {
+		v, err := fromJSON(x)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = v
+	}
+	return res, nil
+}
+
+func fromJSONTagged(tag string, x interface{}) (Value, error) {
+	switch tag {
+	case "$rat":
+		if str, ok := x.(string); ok {
+			if n, ok := ParseNum(str); ok {
+				return n, nil
+			}
+		}
+	case "$keyword":
+		if str, ok := x.(string); ok {
+			return NewKeyword(str), nil
+		}
+	case "$atom":
+		if str, ok := x.(string); ok {
+			return NewAtom(str), nil
+		}
+	case "$list":
+		if xs, ok := x.([]interface{}); ok {
+			vs, err := fromJSONArray(xs)
+			if err != nil {
+				return nil, err
+			}
+			return NewList(vs...), nil
+		}
+	case "$dict":
+		if xs, ok := x.([]interface{}); ok {
+			return fromJSONPairs(xs)
+		}
+	default:
+		return nil, fmt.Errorf("unknown JSON tag %s", tag)
+	}
+	return nil, fmt.Errorf("invalid %s value %v", tag, x)
+}
+
+func fromJSONPairs(xs []interface{}) (Value, error) {
+	res := NewDict()
+	for _, x := range xs {
+		kv, ok := x.([]interface{})
+		if !ok || len(kv) != 2 {
+			return nil, fmt.Errorf("invalid $dict entry %v", x)
+		}
+		vs, err := fromJSONArray(kv)
+		if err != nil {
+			return nil, err
+		}
+		res.Set(vs[0], vs[1])
+	}
+	return res, nil
+}
@@ -1,14 +1,112 @@
 package types
-/*
+
 import (
-	sdk "github.com/cosmos/cosmos-sdk/types"
+	"math/big"
+
+	"github.com/mossid/dr-alice/types/proto"
 )
 
-// I'm just using cosmos-sdk dec implementation
-// might be changed
-type Dec = sdk.Dec
+// Num is an exact rational number of arbitrary precision. Integers are
+// rationals with denominator 1, so arithmetic never overflows or rounds.
+// A Num is immutable once constructed.
+type Num struct {
+	rat *big.Rat
+}
+
+func NewNum(i int64) *Num         { return &Num{new(big.Rat).SetInt64(i)} }
+func NewNumInt(i *big.Int) *Num   { return &Num{new(big.Rat).SetInt(i)} }
+func NewNumRat(r *big.Rat) *Num   { return &Num{new(big.Rat).Set(r)} }
+func (*Num) Type() ValueType      { return TypeNumber }
+func (n *Num) Rat() *big.Rat      { return new(big.Rat).Set(n.rat) }
+func (n *Num) IsInt() bool        { return n.rat.IsInt() }
+func (n *Num) Sign() int          { return n.rat.Sign() }
+func (n *Num) Cmp(n0 *Num) int    { return n.rat.Cmp(n0.rat) }
+func (n *Num) Equal(v Value) bool { n0, ok := v.(*Num); return ok && n.Cmp(n0) == 0 }
+
+// ParseNum reads an integer ("-12"), a decimal ("1.25") or a fraction ("3/4").
+func ParseNum(str string) (*Num, bool) {
+	r, ok := new(big.Rat).SetString(str)
+	if !ok {
+		return nil, false
+	}
+	return &Num{r}, true
+}
+
+// Int64 returns n if it is an integer that fits in an int64.
+func (n *Num) Int64() (int64, bool) {
+	if !n.rat.IsInt() || !n.rat.Num().IsInt64() {
+		return 0, false
+	}
+	return n.rat.Num().Int64(), true
+}
+
+// String prints integers as integers, fractions with a finite decimal
+// expansion as decimals and all other fractions as "a/b".
+func (n *Num) String() string {
+	if n.rat.IsInt() {
+		return n.rat.Num().String()
+	}
+	if prec, ok := decimalPlaces(n.rat.Denom()); ok {
+		return n.rat.FloatString(prec)
+	}
+	return n.rat.String()
+}
+
+// decimalPlaces returns the number of digits after the point needed to
+// write 1/d exactly, if d has no prime factors other than 2 and 5.
+func decimalPlaces(d *big.Int) (int, bool) {
+	twos := int(d.TrailingZeroBits())
+	rest := new(big.Int).Rsh(d, uint(twos))
+	fives := 0
+	five := big.NewInt(5)
+	m := new(big.Int)
+	for rest.Cmp(big.NewInt(1)) != 0 {
+		q, r := new(big.Int).QuoRem(rest, five, m)
+		if r.Sign() != 0 {
+			return 0, false
+		}
+		rest = q
+		fives++
+	}
+	if twos > fives {
+		return twos, true
+	}
+	return fives, true
+}
 
-func NewDec(i int64) Dec {
-	return sdk.NewDec(i)
+func (n *Num) Proto() *proto.Value {
+	if i, ok := n.Int64(); ok {
+		return &proto.Value{Value: &proto.Value_Num{&proto.Num{Num: i}}}
+	}
+	return &proto.Value{Value: &proto.Value_Num{&proto.Num{Rat: n.rat.RatString()}}}
 }
-*/
+func (n *Num) Unproto(pv *proto.Value) {
+	pa := pv.GetNum()
+	if pa == nil {
+		return
+	}
+	if res := unprotoNum(pa); res != nil {
+		*n = *res
+	}
+}
+
+func unprotoNum(pa *proto.Num) *Num {
+	if pa.Rat == "" {
+		return NewNum(pa.Num)
+	}
+	res, ok := ParseNum(pa.Rat)
+	if !ok {
+		return nil
+	}
+	return res
+}
+
+func (n *Num) Neg() *Num { return &Num{new(big.Rat).Neg(n.rat)} }
+func (n *Num) Abs() *Num { return &Num{new(big.Rat).Abs(n.rat)} }
+
+func (n *Num) Add(n0 *Num) *Num { return &Num{new(big.Rat).Add(n.rat, n0.rat)} }
+func (n *Num) Sub(n0 *Num) *Num { return &Num{new(big.Rat).Sub(n.rat, n0.rat)} }
+func (n *Num) Mul(n0 *Num) *Num { return &Num{new(big.Rat).Mul(n.rat, n0.rat)} }
+
+// Quo returns n / n0. It panics if n0 is zero.
+func (n *Num) Quo(n0 *Num) *Num { return &Num{new(big.Rat).Quo(n.rat, n0.rat)} }
@@ -27,6 +27,9 @@ It has these top-level messages:
 	IVPair
 	Intmap
 	State
+	EnvNode
+	EnvSlot
+	Binding
 */
 package proto
 
@@ -62,6 +65,10 @@ type Value struct {
 	//	*Value_Env
 	//	*Value_State
 	Value isValue_Value `protobuf_oneof:"value"`
+	// Immutable envs share most of their bindings, so they refer to them by
+	// index into these tables, which only the outermost value carries.
+	Nodes    []*EnvNode `protobuf:"bytes,15,rep,name=nodes" json:"nodes,omitempty"`
+	Bindings []*Binding `protobuf:"bytes,16,rep,name=bindings" json:"bindings,omitempty"`
 }
 
 func (m *Value) Reset()                    { *m = Value{} }
@@ -138,6 +145,20 @@ func (m *Value) GetValue() isValue_Value {
 	return nil
 }
 
+func (m *Value) GetNodes() []*EnvNode {
+	if m != nil {
+		return m.Nodes
+	}
+	return nil
+}
+
+func (m *Value) GetBindings() []*Binding {
+	if m != nil {
+		return m.Bindings
+	}
+	return nil
+}
+
 func (m *Value) GetAtom() *Atom {
 	if x, ok := m.GetValue().(*Value_Atom); ok {
 		return x.Atom
@@ -570,8 +591,9 @@ func (m *Keyword) GetKeyword() string {
 	return ""
 }
 
+// Strings may hold arbitrary bytes, which proto3 string fields reject.
 type String struct {
-	String_ string `protobuf:"bytes,1,opt,name=string" json:"string,omitempty"`
+	String_ []byte `protobuf:"bytes,1,opt,name=string" json:"string,omitempty"`
 }
 
 func (m *String) Reset()                    { *m = String{} }
@@ -579,15 +601,18 @@ func (m *String) String() string            { return proto1.CompactTextString(m)
 func (*String) ProtoMessage()               {}
 func (*String) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
 
-func (m *String) GetString_() string {
+func (m *String) GetString_() []byte {
 	if m != nil {
 		return m.String_
 	}
-	return ""
+	return nil
 }
 
+// Integers that fit in 64 bits are stored in num, any other number in
+// rat as "a/b".
 type Num struct {
-	Num int64 `protobuf:"varint,1,opt,name=num" json:"num,omitempty"`
+	Num int64  `protobuf:"varint,1,opt,name=num" json:"num,omitempty"`
+	Rat string `protobuf:"bytes,2,opt,name=rat" json:"rat,omitempty"`
 }
 
 func (m *Num) Reset()                    { *m = Num{} }
@@ -602,6 +627,13 @@ func (m *Num) GetNum() int64 {
 	return 0
 }
 
+func (m *Num) GetRat() string {
+	if m != nil {
+		return m.Rat
+	}
+	return ""
+}
+
 type Boolean struct {
 	Boolean bool `protobuf:"varint,1,opt,name=boolean" json:"boolean,omitempty"`
 }
@@ -802,8 +834,18 @@ func (m *SVPair) GetValue() *Value {
 	return nil
 }
 
+// Env pairs hold the visible bindings sorted by name. Mutable envs are
+// restored as map envs, immutable ones as list envs.
+// Env pairs hold the visible bindings of a mutable env sorted by name.
+// An immutable env is instead the list of bindings at top, newest first,
+// in front of the trie at root. Both are one more than an index into the
+// tables of the outermost value, or 0 when empty. list envs have no trie.
 type Env struct {
-	Pairs []*SVPair `protobuf:"bytes,1,rep,name=pairs" json:"pairs,omitempty"`
+	Pairs   []*SVPair `protobuf:"bytes,1,rep,name=pairs" json:"pairs,omitempty"`
+	Mutable bool      `protobuf:"varint,2,opt,name=mutable" json:"mutable,omitempty"`
+	Root    uint64    `protobuf:"varint,3,opt,name=root" json:"root,omitempty"`
+	Top     uint64    `protobuf:"varint,4,opt,name=top" json:"top,omitempty"`
+	List    bool      `protobuf:"varint,5,opt,name=list" json:"list,omitempty"`
 }
 
 func (m *Env) Reset()                    { *m = Env{} }
@@ -818,6 +860,34 @@ func (m *Env) GetPairs() []*SVPair {
 	return nil
 }
 
+func (m *Env) GetMutable() bool {
+	if m != nil {
+		return m.Mutable
+	}
+	return false
+}
+
+func (m *Env) GetRoot() uint64 {
+	if m != nil {
+		return m.Root
+	}
+	return 0
+}
+
+func (m *Env) GetTop() uint64 {
+	if m != nil {
+		return m.Top
+	}
+	return 0
+}
+
+func (m *Env) GetList() bool {
+	if m != nil {
+		return m.List
+	}
+	return false
+}
+
 type IVPair struct {
 	Key   uint64 `protobuf:"varint,1,opt,name=key" json:"key,omitempty"`
 	Value *Value `protobuf:"bytes,2,opt,name=value" json:"value,omitempty"`
@@ -842,8 +912,10 @@ func (m *IVPair) GetValue() *Value {
 	return nil
 }
 
+// Intmap pairs are sorted by key. next is the key the next insert uses.
 type Intmap struct {
 	Pairs []*IVPair `protobuf:"bytes,1,rep,name=pairs" json:"pairs,omitempty"`
+	Next  uint64    `protobuf:"varint,2,opt,name=next" json:"next,omitempty"`
 }
 
 func (m *Intmap) Reset()                    { *m = Intmap{} }
@@ -858,6 +930,13 @@ func (m *Intmap) GetPairs() []*IVPair {
 	return nil
 }
 
+func (m *Intmap) GetNext() uint64 {
+	if m != nil {
+		return m.Next
+	}
+	return 0
+}
+
 type State struct {
 	Env   *Env    `protobuf:"bytes,1,opt,name=env" json:"env,omitempty"`
 	State *Intmap `protobuf:"bytes,2,opt,name=state" json:"state,omitempty"`
@@ -882,6 +961,99 @@ func (m *State) GetState() *Intmap {
 	return nil
 }
 
+// EnvNode is a node of the trie of an immutable env. Its children come
+// before it in the table.
+type EnvNode struct {
+	Bitmap uint32     `protobuf:"varint,1,opt,name=bitmap" json:"bitmap,omitempty"`
+	Slots  []*EnvSlot `protobuf:"bytes,2,rep,name=slots" json:"slots,omitempty"`
+}
+
+func (m *EnvNode) Reset()                    { *m = EnvNode{} }
+func (m *EnvNode) String() string            { return proto1.CompactTextString(m) }
+func (*EnvNode) ProtoMessage()               {}
+func (*EnvNode) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{19} }
+
+func (m *EnvNode) GetBitmap() uint32 {
+	if m != nil {
+		return m.Bitmap
+	}
+	return 0
+}
+
+func (m *EnvNode) GetSlots() []*EnvSlot {
+	if m != nil {
+		return m.Slots
+	}
+	return nil
+}
+
+// EnvSlot is a binding, or the subtree at node if node is not 0.
+type EnvSlot struct {
+	Key   string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Value *Value `protobuf:"bytes,2,opt,name=value" json:"value,omitempty"`
+	Node  uint64 `protobuf:"varint,3,opt,name=node" json:"node,omitempty"`
+}
+
+func (m *EnvSlot) Reset()                    { *m = EnvSlot{} }
+func (m *EnvSlot) String() string            { return proto1.CompactTextString(m) }
+func (*EnvSlot) ProtoMessage()               {}
+func (*EnvSlot) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{20} }
+
+func (m *EnvSlot) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *EnvSlot) GetValue() *Value {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func (m *EnvSlot) GetNode() uint64 {
+	if m != nil {
+		return m.Node
+	}
+	return 0
+}
+
+// Binding is a cell of a list of bindings. next comes before it in the
+// table.
+type Binding struct {
+	Name  string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Value *Value `protobuf:"bytes,2,opt,name=value" json:"value,omitempty"`
+	Next  uint64 `protobuf:"varint,3,opt,name=next" json:"next,omitempty"`
+}
+
+func (m *Binding) Reset()                    { *m = Binding{} }
+func (m *Binding) String() string            { return proto1.CompactTextString(m) }
+func (*Binding) ProtoMessage()               {}
+func (*Binding) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{21} }
+
+func (m *Binding) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Binding) GetValue() *Value {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func (m *Binding) GetNext() uint64 {
+	if m != nil {
+		return m.Next
+	}
+	return 0
+}
+
 func init() {
 	proto1.RegisterType((*Value)(nil), "proto.Value")
 	proto1.RegisterType((*Atom)(nil), "proto.Atom")
@@ -902,49 +1074,62 @@ func init() {
 	proto1.RegisterType((*IVPair)(nil), "proto.IVPair")
 	proto1.RegisterType((*Intmap)(nil), "proto.Intmap")
 	proto1.RegisterType((*State)(nil), "proto.State")
+	proto1.RegisterType((*EnvNode)(nil), "proto.EnvNode")
+	proto1.RegisterType((*EnvSlot)(nil), "proto.EnvSlot")
+	proto1.RegisterType((*Binding)(nil), "proto.Binding")
 }
 
 func init() { proto1.RegisterFile("value.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 619 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x93, 0xd1, 0x6b, 0x1a, 0x41,
-	0x10, 0xc6, 0xd5, 0xbb, 0x5b, 0xe3, 0x98, 0x04, 0xd9, 0x87, 0x76, 0x29, 0xa5, 0xd8, 0x4d, 0x4a,
-	0x4b, 0xda, 0x4a, 0x69, 0xdf, 0x03, 0x0d, 0x4d, 0xd0, 0x46, 0x42, 0x38, 0xc1, 0xe7, 0x9e, 0xba,
-	0x27, 0x47, 0xbc, 0x3b, 0xb9, 0x5b, 0xaf, 0xe4, 0x8f, 0xea, 0xff, 0x58, 0x66, 0x67, 0x4f, 0xb3,
-	0xad, 0x01, 0x9f, 0xf4, 0xe6, 0xfb, 0xd8, 0x6f, 0x67, 0xe6, 0xb7, 0xd0, 0xad, 0xa2, 0xd5, 0x46,
-	0x0d, 0xd6, 0x45, 0xae, 0x73, 0x1e, 0x98, 0x1f, 0xf9, 0xc7, 0x87, 0x60, 0x8a, 0x65, 0xfe, 0x16,
-	0xfc, 0x48, 0xe7, 0xa9, 0x68, 0xf6, 0x9b, 0x1f, 0xba, 0x5f, 0xbb, 0x64, 0x1b, 0x7c, 0xd7, 0x79,
-	0x3a, 0x6c, 0x84, 0x46, 0xe2, 0x17, 0xd0, 0x7e, 0x50, 0x8f, 0xbf, 0xf3, 0x62, 0x21, 0x5a, 0xc6,
-	0x75, 0x6a, 0x5d, 0xb7, 0x54, 0x1d, 0x36, 0xc2, 0xda, 0xc0, 0xdf, 0x03, 0x2b, 0x75, 0x91, 0x64,
-	0x4b, 0xe1, 0x19, 0xeb, 0x89, 0xb5, 0x4e, 0x4c, 0x71, 0xd8, 0x08, 0xad, 0xcc, 0xdf, 0x80, 0x97,
-	0x6d, 0x52, 0xe1, 0x1b, 0x17, 0x58, 0xd7, 0xdd, 0x06, 0x53, 0x51, 0xc0, 0xd0, 0x59, 0x9e, 0xaf,
-	0x54, 0x94, 0x89, 0xc0, 0x09, 0xbd, 0xa2, 0x2a, 0x86, 0x5a, 0x03, 0xf6, 0xb0, 0x4a, 0x4a, 0x2d,
-	0x98, 0xd3, 0xc3, 0x38, 0x29, 0x35, 0xf6, 0x80, 0x12, 0xde, 0xab, 0x52, 0x73, 0x9d, 0x17, 0xa2,
-	0xed, 0xdc, 0x6b, 0x6a, 0x8a, 0x78, 0x2f, 0x92, 0xd1, 0xb8, 0x2e, 0x92, 0xf4, 0x26, 0x13, 0x47,
-	0x8e, 0xf1, 0xde, 0x14, 0xd1, 0x48, 0x32, 0x86, 0x2e, 0x92, 0xb9, 0x16, 0x1d, 0x27, 0xf4, 0x47,
-	0x32, 0x37, 0xa1, 0x28, 0x61, 0x8f, 0x85, 0x8a, 0x05, 0x38, 0x3d, 0x86, 0x2a, 0xc6, 0x1e, 0x0b,
-	0x15, 0x63, 0xd6, 0x2a, 0x4a, 0x67, 0x8b, 0x48, 0x74, 0x9d, 0xac, 0xb1, 0x29, 0x62, 0x16, 0xc9,
-	0xfc, 0x0b, 0x74, 0xe8, 0x5f, 0xa8, 0xe6, 0xe2, 0xd8, 0x78, 0x7b, 0x8e, 0x37, 0x54, 0xf3, 0x61,
-	0x23, 0xdc, 0x99, 0x30, 0x5a, 0x65, 0x95, 0x38, 0x71, 0xa2, 0xaf, 0xb3, 0x0a, 0xa3, 0x55, 0x56,
-	0xf1, 0x73, 0x08, 0x4a, 0x1d, 0x69, 0x25, 0x4e, 0x8d, 0xe3, 0x78, 0xbb, 0xa6, 0x48, 0xab, 0x61,
-	0x23, 0x24, 0xf1, 0xaa, 0x0d, 0x81, 0x81, 0x47, 0xbe, 0x02, 0x1f, 0x91, 0xe0, 0xfc, 0x09, 0x2d,
-	0x1d, 0xc2, 0x43, 0x9e, 0x41, 0xdb, 0x82, 0xc0, 0xc5, 0x8e, 0x14, 0x72, 0xd4, 0x9f, 0xb2, 0x0f,
-	0x8c, 0x10, 0xe0, 0x2f, 0xb6, 0x84, 0x90, 0xc5, 0x7e, 0xc9, 0x97, 0xe0, 0xdd, 0x6d, 0x52, 0xde,
-	0x23, 0x2e, 0x50, 0xf3, 0x0c, 0x09, 0x78, 0xbe, 0xdd, 0x39, 0x9e, 0x5f, 0x43, 0x81, 0x86, 0xa3,
-	0x2d, 0x02, 0xf2, 0x13, 0xf8, 0xb8, 0x6f, 0x7e, 0x0e, 0xcc, 0xdc, 0xb8, 0x14, 0xcd, 0xbe, 0xf7,
-	0xa4, 0x31, 0x03, 0x7b, 0x68, 0x35, 0x39, 0x00, 0x46, 0x8b, 0x3f, 0xd0, 0x2f, 0x80, 0xd1, 0xfe,
-	0xf9, 0x29, 0xb4, 0xe2, 0xcc, 0xde, 0xbc, 0x15, 0x67, 0x72, 0x0c, 0xec, 0x76, 0x7a, 0x1f, 0x25,
-	0x05, 0x4e, 0xfc, 0x41, 0x3d, 0xda, 0x77, 0xe4, 0x1e, 0x83, 0x02, 0x97, 0x76, 0x96, 0xf6, 0x0d,
-	0xb9, 0x0e, 0x3b, 0xe6, 0x8f, 0xe0, 0x23, 0x40, 0xfc, 0x0c, 0x82, 0x75, 0x94, 0x14, 0xf5, 0xa5,
-	0x6a, 0x2e, 0x28, 0x29, 0x24, 0x0d, 0x07, 0x16, 0xaa, 0x18, 0x07, 0x86, 0x90, 0x61, 0xae, 0x6f,
-	0xb0, 0x92, 0xbf, 0x80, 0x11, 0x15, 0x66, 0x5d, 0xc5, 0x92, 0x8e, 0xc1, 0x75, 0x15, 0xcb, 0x12,
-	0x3b, 0x9e, 0xe5, 0x8b, 0x44, 0x95, 0xa2, 0xb5, 0xaf, 0x63, 0xd2, 0xf8, 0x6b, 0xe2, 0xc7, 0xfb,
-	0x97, 0x1f, 0x43, 0x8f, 0xbc, 0x81, 0xce, 0x96, 0x3b, 0x0c, 0x29, 0xd5, 0x2a, 0xae, 0x99, 0xc0,
-	0xff, 0xfc, 0xdd, 0x96, 0xec, 0xd6, 0x1e, 0xb2, 0x6b, 0xae, 0xe5, 0x25, 0xb0, 0x09, 0x4d, 0xaf,
-	0xb7, 0x9b, 0x5e, 0xe7, 0xf0, 0x79, 0x5d, 0x80, 0x77, 0x9d, 0x55, 0xcf, 0x8d, 0x6b, 0xe2, 0x8c,
-	0xeb, 0x12, 0xd8, 0xe8, 0xbf, 0x2c, 0xff, 0xf0, 0xac, 0xcf, 0xc0, 0x46, 0x99, 0x4e, 0xa3, 0xf5,
-	0x73, 0x71, 0x23, 0x27, 0xee, 0x27, 0x04, 0xe6, 0x31, 0xd5, 0x93, 0x6c, 0xee, 0x9d, 0x24, 0x9e,
-	0x45, 0xef, 0xd0, 0x9d, 0x13, 0x25, 0xd9, 0x67, 0x38, 0x63, 0xa6, 0xf8, 0xed, 0x6f, 0x00, 0x00,
-	0x00, 0xff, 0xff, 0xc7, 0xdb, 0xd6, 0x25, 0xca, 0x05, 0x00, 0x00,
+	// 780 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x54, 0xcf, 0x6f, 0x33, 0x35,
+	0x10, 0x4d, 0xb2, 0x3f, 0x92, 0x4c, 0xda, 0x12, 0xf9, 0x00, 0x16, 0x42, 0x28, 0xb8, 0x45, 0x40,
+	0x41, 0x15, 0x82, 0x3b, 0x52, 0x2b, 0x5a, 0x52, 0x5a, 0x55, 0x95, 0x23, 0x7a, 0x66, 0x93, 0xf5,
+	0x46, 0xab, 0xee, 0xda, 0xd1, 0xae, 0x13, 0xe8, 0x85, 0x7f, 0x1d, 0x34, 0x63, 0x6f, 0x92, 0xe5,
+	0x4b, 0xa5, 0xe8, 0x3b, 0x65, 0x76, 0xde, 0x8b, 0x9f, 0x67, 0xe6, 0x79, 0x60, 0xb4, 0x49, 0x8a,
+	0xb5, 0xba, 0x5a, 0x55, 0xc6, 0x1a, 0x16, 0xd1, 0x8f, 0xf8, 0x37, 0x84, 0xe8, 0x05, 0xd3, 0xec,
+	0x2b, 0x08, 0x13, 0x6b, 0x4a, 0xde, 0x9d, 0x74, 0xbf, 0x1d, 0xfd, 0x34, 0x72, 0xb4, 0xab, 0x6b,
+	0x6b, 0xca, 0x69, 0x47, 0x12, 0xc4, 0x2e, 0xa1, 0xff, 0xaa, 0xde, 0xfe, 0x32, 0x55, 0xca, 0x7b,
+	0xc4, 0x3a, 0xf3, 0xac, 0x07, 0x97, 0x9d, 0x76, 0x64, 0x43, 0x60, 0xdf, 0x40, 0x5c, 0xdb, 0x2a,
+	0xd7, 0x4b, 0x1e, 0x10, 0xf5, 0xd4, 0x53, 0x67, 0x94, 0x9c, 0x76, 0xa4, 0x87, 0xd9, 0x97, 0x10,
+	0xe8, 0x75, 0xc9, 0x43, 0x62, 0x81, 0x67, 0x3d, 0xad, 0x51, 0x15, 0x01, 0x14, 0x9d, 0x1b, 0x53,
+	0xa8, 0x44, 0xf3, 0xa8, 0x25, 0x7a, 0xe3, 0xb2, 0x28, 0xea, 0x09, 0x58, 0x43, 0x91, 0xd7, 0x96,
+	0xc7, 0xad, 0x1a, 0x1e, 0xf3, 0xda, 0x62, 0x0d, 0x08, 0xe1, 0xbd, 0x36, 0x6a, 0x61, 0x4d, 0xc5,
+	0xfb, 0xad, 0x7b, 0xbd, 0x50, 0x12, 0xef, 0xe5, 0x60, 0x24, 0xae, 0xaa, 0xbc, 0xbc, 0xd3, 0x7c,
+	0xd0, 0x22, 0x3e, 0x53, 0x12, 0x89, 0x0e, 0x46, 0xd1, 0x34, 0x5f, 0x58, 0x3e, 0x6c, 0x89, 0xfe,
+	0x9a, 0x2f, 0x48, 0x14, 0x21, 0xac, 0xb1, 0x52, 0x19, 0x87, 0x56, 0x8d, 0x52, 0x65, 0x58, 0x63,
+	0xa5, 0x32, 0xd4, 0x2a, 0x92, 0x72, 0x9e, 0x26, 0x7c, 0xd4, 0xd2, 0x7a, 0xa4, 0x24, 0x6a, 0x39,
+	0x98, 0xfd, 0x08, 0x43, 0x17, 0x49, 0xb5, 0xe0, 0x27, 0xc4, 0x1d, 0xb7, 0xb8, 0x52, 0x2d, 0xa6,
+	0x1d, 0xb9, 0x23, 0xa1, 0xb4, 0xd2, 0x1b, 0x7e, 0xda, 0x92, 0xbe, 0xd5, 0x1b, 0x94, 0x56, 0x7a,
+	0xc3, 0x2e, 0x20, 0xaa, 0x6d, 0x62, 0x15, 0x3f, 0x23, 0xc6, 0xc9, 0x76, 0x4c, 0x89, 0x55, 0xd3,
+	0x8e, 0x74, 0x20, 0xb2, 0xb4, 0x49, 0x55, 0xcd, 0x3f, 0x99, 0x04, 0x7b, 0x23, 0xb8, 0xd5, 0x9b,
+	0x27, 0x93, 0x2a, 0xe9, 0x40, 0x76, 0x09, 0x83, 0x79, 0xae, 0xd3, 0x5c, 0x2f, 0x6b, 0x3e, 0x6e,
+	0x11, 0x6f, 0x5c, 0x5a, 0x6e, 0xf1, 0x9b, 0x3e, 0x44, 0x64, 0x47, 0xf1, 0x39, 0x84, 0x68, 0x32,
+	0xc6, 0xf6, 0xfc, 0x37, 0x74, 0x86, 0x13, 0xe7, 0xd0, 0xf7, 0xd6, 0x62, 0x7c, 0xe7, 0x3d, 0xc7,
+	0x68, 0x3e, 0xc5, 0x04, 0x62, 0x67, 0x2a, 0xf6, 0xe9, 0xd6, 0x73, 0x48, 0x39, 0x69, 0x2c, 0x26,
+	0xbe, 0x83, 0xe0, 0x69, 0x5d, 0xb2, 0xb1, 0x73, 0x1a, 0x62, 0x81, 0xf3, 0xd6, 0x18, 0x82, 0x2a,
+	0xb1, 0x64, 0xe6, 0xa1, 0xc4, 0x10, 0x15, 0xbd, 0xaf, 0x50, 0xb1, 0x31, 0x1e, 0xfe, 0x65, 0xb0,
+	0xb5, 0x99, 0xf8, 0x01, 0x42, 0xf4, 0x14, 0xbb, 0x80, 0x98, 0x6a, 0xa8, 0x79, 0x77, 0x12, 0xec,
+	0x35, 0x8f, 0x1e, 0x94, 0xf4, 0x98, 0xb8, 0x82, 0xd8, 0x99, 0xeb, 0x48, 0x3e, 0x87, 0xd8, 0x79,
+	0x8c, 0x9d, 0x41, 0x2f, 0xd3, 0xbe, 0xdc, 0x5e, 0xa6, 0xc5, 0x23, 0xc4, 0x0f, 0x2f, 0xcf, 0x49,
+	0x5e, 0xe1, 0x54, 0x5f, 0xd5, 0x9b, 0x7f, 0xab, 0xed, 0x63, 0x10, 0x60, 0xc2, 0x77, 0x97, 0xf7,
+	0x0e, 0x30, 0x7c, 0xe3, 0xbf, 0x87, 0x10, 0x4d, 0xca, 0xce, 0x21, 0x5a, 0x25, 0x79, 0xd5, 0x5c,
+	0xaa, 0xf1, 0x9e, 0x53, 0x92, 0x0e, 0x13, 0x9f, 0x41, 0x20, 0x55, 0x46, 0x0d, 0x53, 0x19, 0xe9,
+	0x86, 0x64, 0x5d, 0xf1, 0x27, 0xc4, 0xce, 0x79, 0x34, 0xc0, 0x6a, 0xe9, 0x8e, 0xc1, 0x01, 0x56,
+	0xcb, 0x1a, 0x2b, 0x9e, 0x9b, 0x34, 0x57, 0x35, 0xef, 0x1d, 0xaa, 0xd8, 0x61, 0xec, 0x0b, 0xe7,
+	0xd1, 0xe0, 0xff, 0x1e, 0x25, 0x87, 0x8a, 0x3b, 0x18, 0x6e, 0xbd, 0x8d, 0x22, 0xb5, 0x2a, 0xb2,
+	0xc6, 0x25, 0x18, 0xb3, 0xaf, 0xb7, 0xaf, 0xa7, 0x77, 0xe0, 0xf5, 0x34, 0x6f, 0x47, 0xfc, 0x02,
+	0xf1, 0xcc, 0x75, 0x6f, 0xbc, 0xeb, 0xde, 0xf0, 0xf8, 0x7e, 0xfd, 0x03, 0xc1, 0xad, 0xde, 0xbc,
+	0xd7, 0xae, 0xd9, 0x7e, 0xbb, 0xd0, 0x3b, 0xe5, 0xda, 0x26, 0xf3, 0xc2, 0x9d, 0x38, 0x90, 0xcd,
+	0x27, 0x16, 0x50, 0x19, 0x63, 0xa9, 0xd8, 0x50, 0x52, 0x8c, 0xf7, 0xb1, 0x66, 0x45, 0x2b, 0x30,
+	0x94, 0x18, 0x22, 0x8b, 0x16, 0x59, 0x44, 0x7f, 0xa6, 0x18, 0xef, 0x7f, 0xff, 0xc1, 0xfd, 0xc3,
+	0xe3, 0xef, 0x7f, 0x0d, 0xf1, 0xbd, 0xb6, 0x65, 0xb2, 0x7a, 0xaf, 0x84, 0xfb, 0x56, 0x09, 0x0c,
+	0x42, 0xad, 0xfe, 0x76, 0x8f, 0x23, 0x94, 0x14, 0x8b, 0xdf, 0x21, 0xa2, 0xc5, 0xd0, 0x4c, 0xac,
+	0x7b, 0x70, 0x62, 0x78, 0xbe, 0xdb, 0x29, 0xed, 0x79, 0x38, 0x75, 0xbf, 0x52, 0xc4, 0x6f, 0xd0,
+	0xf7, 0xeb, 0x03, 0xdf, 0xed, 0x3c, 0x47, 0x8c, 0x0e, 0x3c, 0x95, 0xfe, 0x8b, 0x76, 0x53, 0x61,
+	0x6c, 0x63, 0x9e, 0xbd, 0xad, 0x33, 0x2b, 0x8c, 0x95, 0x0e, 0x14, 0x33, 0xe8, 0xfb, 0xcc, 0xc7,
+	0x0d, 0x96, 0x2a, 0x35, 0xa9, 0x6a, 0x46, 0x82, 0xb1, 0xf8, 0x03, 0xfa, 0x7e, 0x67, 0x11, 0x9c,
+	0x94, 0xaa, 0xb1, 0x1c, 0xc6, 0x47, 0x1f, 0x8b, 0x0d, 0x0c, 0x76, 0x0d, 0x9c, 0xc7, 0xc4, 0xfb,
+	0xf9, 0xbf, 0x01, 0x00, 0xb3, 0x6d, 0xbe, 0x8f, 0x8b, 0x07, 0x00, 0x00,
 }
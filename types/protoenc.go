@@ -0,0 +1,334 @@
+package types
+
+import (
+	"fmt"
+	"math/bits"
+
+	"github.com/mossid/dr-alice/types/proto"
+)
+
+// protoEncoder converts values to protobuf. Immutable envs share their
+// tries and binding lists with the envs they were built from, so rather
+// than copying them into every closure, each trie node and binding is
+// stored once in a table of the outermost value and referred to by index.
+type protoEncoder struct {
+	nodes    []*proto.EnvNode
+	bindings []*proto.Binding
+
+	nodeIx    map[*hnode]uint64
+	bindingIx map[*node]uint64
+}
+
+// toProto converts v along with the tables of the envs it holds.
+func toProto(v Value) *proto.Value {
+	e := &protoEncoder{
+		nodeIx:    make(map[*hnode]uint64),
+		bindingIx: make(map[*node]uint64),
+	}
+	res := e.value(v)
+	res.Nodes, res.Bindings = e.nodes, e.bindings
+	return res
+}
+
+// value converts v, which may be nil like the values of refs and of
+// bindings to (do), to a value with nothing set.
+func (e *protoEncoder) value(v Value) *proto.Value {
+	switch v := v.(type) {
+	case nil:
+		return &proto.Value{}
+	case *List:
+		var vs []*proto.Value
+		for ; v != nil; v = v.Tail {
+			vs = append(vs, e.value(v.Head))
+		}
+		return &proto.Value{Value: &proto.Value_List{&proto.List{vs}}}
+	case *Vector:
+		vs := make([]*proto.Value, 0, v.n)
+		v.Iterate(func(_ int, x Value) bool {
+			vs = append(vs, e.value(x))
+			return false
+		})
+		return &proto.Value{Value: &proto.Value_Vector{&proto.Vector{vs}}}
+	case *Dict:
+		entries := v.entries()
+		pairs := make([]*proto.KVPair, len(entries))
+		for i, entry := range entries {
+			pairs[i] = &proto.KVPair{e.value(entry.k), e.value(entry.v)}
+		}
+		return &proto.Value{Value: &proto.Value_Dict{&proto.Dict{pairs}}}
+	case *Lambda:
+		return &proto.Value{Value: &proto.Value_Lambda{e.lambda(v)}}
+	case *LambdaRec:
+		return &proto.Value{Value: &proto.Value_LambdaRec{&proto.LambdaRec{v.Self, e.lambda(v.Lambda)}}}
+	case Env:
+		return &proto.Value{Value: &proto.Value_Env{e.env(v)}}
+	case *State:
+		return &proto.Value{Value: &proto.Value_State{&proto.State{e.env(v.Env), e.intmap(v.Refs)}}}
+	default:
+		return v.Proto()
+	}
+}
+
+func (e *protoEncoder) lambda(l *Lambda) *proto.Lambda {
+	bodies := make([]*proto.Value, len(l.Bodies))
+	for i, body := range l.Bodies {
+		bodies[i] = e.value(body)
+	}
+	var env *proto.Env
+	if l.Env != nil {
+		env = e.env(l.Env)
+	}
+	return &proto.Lambda{l.Args, bodies, env}
+}
+
+func (e *protoEncoder) env(env Env) *proto.Env {
+	switch env := env.(type) {
+	case *hamtEnv:
+		return &proto.Env{Root: e.node(env.root), Top: e.binding(env.top)}
+	case *listEnv:
+		return &proto.Env{Top: e.binding(env.top), List: true}
+	default:
+		names, m := bindings(env)
+		pairs := make([]*proto.SVPair, len(names))
+		for i, name := range names {
+			pairs[i] = &proto.SVPair{name, e.value(m[name])}
+		}
+		return &proto.Env{Pairs: pairs, Mutable: !env.IsImmutable()}
+	}
+}
+
+// node adds n to the table after its children and returns one more than
+// its index, or 0 if n is nil.
+func (e *protoEncoder) node(n *hnode) uint64 {
+	if n == nil {
+		return 0
+	}
+	if ix, ok := e.nodeIx[n]; ok {
+		return ix
+	}
+	slots := make([]*proto.EnvSlot, len(n.slots))
+	for i, s := range n.slots {
+		if s.sub != nil {
+			slots[i] = &proto.EnvSlot{Node: e.node(s.sub)}
+		} else {
+			slots[i] = &proto.EnvSlot{Key: s.key, Value: e.value(s.e.v)}
+		}
+	}
+	e.nodes = append(e.nodes, &proto.EnvNode{n.bitmap, slots})
+	e.nodeIx[n] = uint64(len(e.nodes))
+	return e.nodeIx[n]
+}
+
+// binding adds the bindings from top on to the table, oldest first, and
+// returns one more than the index of top, or 0 if top is nil. Lists can
+// be long, so they are walked rather than recursed into.
+func (e *protoEncoder) binding(top *node) uint64 {
+	var cells []*node
+	for ptr := top; ptr != nil; ptr = ptr.next {
+		if _, ok := e.bindingIx[ptr]; ok {
+			break
+		}
+		cells = append(cells, ptr)
+	}
+	for i := len(cells) - 1; i >= 0; i-- {
+		c := cells[i]
+		e.bindings = append(e.bindings, &proto.Binding{c.name, e.value(c.value), e.bindingIx[c.next]})
+		e.bindingIx[c] = uint64(len(e.bindings))
+	}
+	return e.bindingIx[top]
+}
+
+func (e *protoEncoder) intmap(m *Intmap) *proto.Intmap {
+	ixs := m.keys()
+	pairs := make([]*proto.IVPair, len(ixs))
+	for i, ix := range ixs {
+		pairs[i] = &proto.IVPair{ix, e.value(m.m[ix])}
+	}
+	return &proto.Intmap{pairs, m.next}
+}
+
+// protoDecoder converts values back from protobuf, restoring the sharing
+// between envs that refer to the same table entries. It records the first
+// malformed part it finds in err.
+type protoDecoder struct {
+	tables   *proto.Value
+	nodes    []decodedNode
+	bindings []*node
+	err      error
+}
+
+// decodedNode is a trie node along with where it was found: its shift and
+// the low hash bits its keys share.
+type decodedNode struct {
+	n      *hnode
+	shift  uint
+	prefix uint64
+}
+
+func newProtoDecoder(pv *proto.Value) *protoDecoder {
+	return &protoDecoder{
+		tables:   pv,
+		nodes:    make([]decodedNode, len(pv.GetNodes())),
+		bindings: make([]*node, len(pv.GetBindings())),
+	}
+}
+
+func (d *protoDecoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("cannot decode value: "+format, args...)
+	}
+}
+
+// elem decodes pv, which must be set as it is an element of a list,
+// vector or dict.
+func (d *protoDecoder) elem(pv *proto.Value) Value {
+	res := d.value(pv)
+	if res == nil {
+		d.fail("missing element")
+	}
+	return res
+}
+
+func (d *protoDecoder) lambda(pa *proto.Lambda) *Lambda {
+	bodies := make([]Value, len(pa.GetBodies()))
+	for i, body := range pa.GetBodies() {
+		bodies[i] = d.elem(body)
+	}
+	return NewLambda(pa.GetArgs(), bodies, d.env(pa.GetEnv()))
+}
+
+func (d *protoDecoder) env(pe *proto.Env) Env {
+	switch {
+	case pe == nil:
+		return nil
+	case pe.GetMutable():
+		res := &mapEnv{make(map[Ident]Value)}
+		for _, kvp := range pe.GetPairs() {
+			res.m[kvp.Key] = d.value(kvp.Value)
+		}
+		return res
+	case pe.GetList():
+		return &listEnv{top: d.binding(pe.GetTop())}
+	default:
+		res := &hamtEnv{root: d.node(pe.GetRoot(), 0, 0), top: d.binding(pe.GetTop())}
+		for ptr := res.top; ptr != nil; ptr = ptr.next {
+			res.depth++
+		}
+		return res
+	}
+}
+
+// node returns the trie at one less than ix, or nil if ix is 0, which is
+// found at shift below keys sharing the low hash bits prefix. Children
+// must come before their parents, which rules out cycles, and each key
+// must be where lookups look for it.
+func (d *protoDecoder) node(ix uint64, shift uint, prefix uint64) *hnode {
+	if ix == 0 {
+		return nil
+	}
+	if ix > uint64(len(d.nodes)) {
+		d.fail("node %d out of range", ix)
+		return nil
+	}
+	if dn := d.nodes[ix-1]; dn.n != nil {
+		if dn.shift != shift || dn.prefix != prefix {
+			d.fail("node %d is shared by different subtrees", ix)
+			return nil
+		}
+		return dn.n
+	}
+
+	pn := d.tables.Nodes[ix-1]
+	bitmap := pn.GetBitmap()
+	switch {
+	case shift >= 64 && bitmap != 0:
+		d.fail("collision node %d has a bitmap", ix)
+		return nil
+	case shift < 64 && bits.OnesCount32(bitmap) != len(pn.GetSlots()):
+		d.fail("node %d has %d slots for bitmap %#x", ix, len(pn.GetSlots()), bitmap)
+		return nil
+	}
+
+	n := &hnode{bitmap: bitmap, slots: make([]*hslot, len(pn.GetSlots()))}
+	for i, ps := range pn.GetSlots() {
+		var chunk uint64
+		if shift < 64 {
+			chunk = uint64(bits.TrailingZeros32(bitmap))
+			bitmap &= bitmap - 1
+		}
+		if ps.GetNode() == 0 {
+			key := ps.GetKey()
+			h := hashKey(key)
+			if !placed(h, shift, prefix, chunk) {
+				d.fail("node %d misplaces %q", ix, key)
+				return nil
+			}
+			n.slots[i] = &hslot{hash: h, key: key, e: dictEntry{v: d.value(ps.GetValue())}}
+			continue
+		}
+		if shift >= 64 || ps.GetNode() >= ix {
+			d.fail("node %d has an invalid subtree %d", ix, ps.GetNode())
+			return nil
+		}
+		sub := d.node(ps.GetNode(), shift+hbits, prefix|chunk<<shift)
+		if sub == nil {
+			return nil
+		}
+		n.slots[i] = &hslot{sub: sub}
+	}
+	d.nodes[ix-1] = decodedNode{n, shift, prefix}
+	return n
+}
+
+// placed reports whether a key hashed to h belongs in the slot for chunk
+// of a node at shift whose keys share the low hash bits prefix. Keys of
+// collision nodes share all their bits.
+func placed(h uint64, shift uint, prefix, chunk uint64) bool {
+	if shift >= 64 {
+		return h == prefix
+	}
+	return h&(1<<shift-1) == prefix && (h>>shift)&hmask == chunk
+}
+
+// binding returns the list of bindings at one less than ix, or nil if ix
+// is 0. Like nodes, each binding must come after the next one.
+func (d *protoDecoder) binding(ix uint64) *node {
+	var pending []uint64
+	for ix != 0 && ix <= uint64(len(d.bindings)) && d.bindings[ix-1] == nil {
+		pending = append(pending, ix)
+		next := d.tables.Bindings[ix-1].GetNext()
+		if next >= ix {
+			d.fail("binding %d has an invalid next %d", ix, next)
+			return nil
+		}
+		ix = next
+	}
+	if ix > uint64(len(d.bindings)) {
+		d.fail("binding %d out of range", ix)
+		return nil
+	}
+	var res *node
+	if ix != 0 {
+		res = d.bindings[ix-1]
+	}
+	for i := len(pending) - 1; i >= 0; i-- {
+		pb := d.tables.Bindings[pending[i]-1]
+		res = &node{pb.GetName(), d.value(pb.GetValue()), res}
+		d.bindings[pending[i]-1] = res
+	}
+	return res
+}
+
+// intmap decodes refs, which may hold nil. Inserts must not reuse an
+// index in use.
+func (d *protoDecoder) intmap(pm *proto.Intmap) *Intmap {
+	res := NewIntmap()
+	res.next = pm.GetNext()
+	for _, kvp := range pm.GetPairs() {
+		if kvp.Key >= res.next {
+			d.fail("ref %d is not below the next one %d", kvp.Key, res.next)
+		}
+		res.m[kvp.Key] = d.value(kvp.Value)
+	}
+	return res
+}
@@ -0,0 +1,127 @@
+package types
+
+// vtrie is a persistent vector: a 32-way trie of leaves plus a tail
+// holding the last, partially filled leaf, as in Clojure. Pushing and
+// setting copy only the path to the changed leaf, so every version stays
+// valid and shares structure with the others.
+type vtrie struct {
+	cnt   int
+	shift uint
+	root  *vnode
+	tail  []Value
+}
+
+// vnode is an internal node if kids is set, and a leaf otherwise.
+type vnode struct {
+	kids []*vnode
+	vals []Value
+}
+
+const (
+	vbits  = 5
+	vwidth = 1 << vbits
+	vmask  = vwidth - 1
+)
+
+var emptyTrie = &vtrie{shift: vbits, root: &vnode{}}
+
+func (t *vtrie) tailOffset() int {
+	if t.cnt < vwidth {
+		return 0
+	}
+	return ((t.cnt - 1) >> vbits) << vbits
+}
+
+func (t *vtrie) get(i int) Value {
+	if i >= t.tailOffset() {
+		return t.tail[i&vmask]
+	}
+	n := t.root
+	for level := t.shift; level > 0; level -= vbits {
+		n = n.kids[(i>>level)&vmask]
+	}
+	return n.vals[i&vmask]
+}
+
+// leaf returns the values of the leaf holding index i.
+func (t *vtrie) leaf(i int) []Value {
+	if i >= t.tailOffset() {
+		return t.tail
+	}
+	n := t.root
+	for level := t.shift; level > 0; level -= vbits {
+		n = n.kids[(i>>level)&vmask]
+	}
+	return n.vals
+}
+
+func (t *vtrie) push(v Value) *vtrie {
+	if t.cnt-t.tailOffset() < vwidth {
+		tail := make([]Value, len(t.tail)+1)
+		copy(tail, t.tail)
+		tail[len(t.tail)] = v
+		return &vtrie{t.cnt + 1, t.shift, t.root, tail}
+	}
+
+	leaf := &vnode{vals: t.tail}
+	root, shift := t.root, t.shift
+	if t.cnt>>vbits > 1<<t.shift {
+		root = &vnode{kids: []*vnode{t.root, newPath(t.shift, leaf)}}
+		shift += vbits
+	} else {
+		root = t.pushLeaf(t.shift, t.root, leaf)
+	}
+	return &vtrie{t.cnt + 1, shift, root, []Value{v}}
+}
+
+func (t *vtrie) pushLeaf(level uint, parent *vnode, leaf *vnode) *vnode {
+	ix := ((t.cnt - 1) >> level) & vmask
+	kids := make([]*vnode, len(parent.kids), ix+1)
+	copy(kids, parent.kids)
+	var kid *vnode
+	switch {
+	case level == vbits:
+		kid = leaf
+	case ix < len(parent.kids):
+		kid = t.pushLeaf(level-vbits, parent.kids[ix], leaf)
+	default:
+		kid = newPath(level-vbits, leaf)
+	}
+	if ix < len(kids) {
+		kids[ix] = kid
+	} else {
+		kids = append(kids, kid)
+	}
+	return &vnode{kids: kids}
+}
+
+func newPath(level uint, n *vnode) *vnode {
+	if level == 0 {
+		return n
+	}
+	return &vnode{kids: []*vnode{newPath(level-vbits, n)}}
+}
+
+func (t *vtrie) set(i int, v Value) *vtrie {
+	if i >= t.tailOffset() {
+		tail := make([]Value, len(t.tail))
+		copy(tail, t.tail)
+		tail[i&vmask] = v
+		return &vtrie{t.cnt, t.shift, t.root, tail}
+	}
+	return &vtrie{t.cnt, t.shift, setPath(t.shift, t.root, i, v), t.tail}
+}
+
+func setPath(level uint, n *vnode, i int, v Value) *vnode {
+	if level == 0 {
+		vals := make([]Value, len(n.vals))
+		copy(vals, n.vals)
+		vals[i&vmask] = v
+		return &vnode{vals: vals}
+	}
+	kids := make([]*vnode, len(n.kids))
+	copy(kids, n.kids)
+	ix := (i >> level) & vmask
+	kids[ix] = setPath(level-vbits, n.kids[ix], i, v)
+	return &vnode{kids: kids}
+}
@@ -2,8 +2,11 @@ package types
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/mossid/dr-alice/types/proto"
 )
@@ -36,6 +39,35 @@ const (
 	TypeState
 )
 
+var typeNames = [...]string{
+	TypeNULL:       "any",
+	TypeAtom:       "atom",
+	TypeKeyword:    "keyword",
+	TypeString:     "string",
+	TypeNumber:     "number",
+	TypeBoolean:    "boolean",
+	TypeList:       "list",
+	TypeVec:        "vector",
+	TypePrimFn:     "primfn",
+	TypeDict:       "dict",
+	TypeRef:        "ref",
+	TypeHandle:     "handle",
+	TypeProcHandle: "proc-handle",
+	TypeLambda:     "lambda",
+	TypeLambdaRec:  "lambda-rec",
+	TypeEnv:        "env",
+	TypeState:      "state",
+}
+
+// String returns the name of t. TypeNULL, which type checks use to accept
+// any value, is "any".
+func (t ValueType) String() string {
+	if int(t) < len(typeNames) {
+		return typeNames[t]
+	}
+	return "ValueType(" + strconv.Itoa(int(t)) + ")"
+}
+
 type Value interface {
 	Type() ValueType
 	String() string
@@ -57,7 +89,7 @@ func (a *Atom) Equal(v Value) bool {
 	return a0.String() == a.String()
 }
 func (a *Atom) Proto() *proto.Value {
-	return &proto.Value{&proto.Value_Atom{&proto.Atom{a.String()}}}
+	return &proto.Value{Value: &proto.Value_Atom{&proto.Atom{a.String()}}}
 }
 
 type Keyword Ident
@@ -74,43 +106,123 @@ func (k *Keyword) Equal(v Value) bool {
 	return k0.String() == k.String()
 }
 func (k *Keyword) Proto() *proto.Value {
-	return &proto.Value{&proto.Value_Keyword{&proto.Keyword{k.String()}}}
+	return &proto.Value{Value: &proto.Value_Keyword{&proto.Keyword{k.Ident()}}}
 }
 
-type String string
+// String keeps the byte offset of each of its characters besides its
+// bytes, unless it is ASCII, so that it can be indexed by character in
+// constant time. A character is a rune, or a single byte that is not
+// valid UTF-8, which is kept as is.
+type String struct {
+	str     string
+	offsets []int
+}
+
+func NewString(str string) *String {
+	res := &String{str: str}
+	for i := 0; i < len(str); i++ {
+		if str[i] >= utf8.RuneSelf {
+			res.offsets = offsets(str)
+			break
+		}
+	}
+	return res
+}
+
+// offsets returns the byte offsets at which the characters of str start,
+// followed by len(str).
+func offsets(str string) []int {
+	res := make([]int, 0, len(str)+1)
+	for i := 0; i < len(str); {
+		res = append(res, i)
+		_, n := utf8.DecodeRuneInString(str[i:])
+		i += n
+	}
+	return append(res, len(str))
+}
 
-func NewString(str string) *String { res := String(str); return &res }
-func (*String) Type() ValueType    { return TypeString }
-func (s *String) String() string   { return string(*s) } // TODO: fix
+func (*String) Type() ValueType  { return TypeString }
+func (s *String) Str() string    { return s.str }
+func (s *String) String() string { return quoteString(s.Str()) }
 func (s *String) Equal(v Value) bool {
 	s0, ok := v.(*String)
 	if !ok {
 		return false
 	}
-	return *s == *s0
+	return s.str == s0.str
 }
 func (s *String) Proto() *proto.Value {
-	return &proto.Value{&proto.Value_String_{&proto.String{s.String()}}}
+	return &proto.Value{Value: &proto.Value_String_{&proto.String{[]byte(s.Str())}}}
+}
+
+// quoteString returns str as a string literal that reads back as str.
+// Bytes that are not valid UTF-8 are written as \x{..} escapes.
+func quoteString(str string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(str); {
+		r, n := utf8.DecodeRuneInString(str[i:])
+		switch {
+		case r == utf8.RuneError && n == 1:
+			fmt.Fprintf(&b, `\x{%x}`, str[i])
+		case r == '"':
+			b.WriteString(`\"`)
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case !unicode.IsPrint(r):
+			fmt.Fprintf(&b, `\u{%x}`, r)
+		default:
+			b.WriteString(str[i : i+n])
+		}
+		i += n
+	}
+	b.WriteByte('"')
+	return b.String()
 }
 
+// Strings are sequences of characters, i.e. runes, each of which is
+// represented as a string of length one.
 func (s *String) Slice(begin, end int) Sequence {
+	n := s.Length()
 	if end == -1 {
-		end = len(*s)
+		end = n
 	}
-	res := (*s)[begin:end]
-	return &res
+	if begin < 0 || end < begin || end > n {
+		panic("slice bounds out of range")
+	}
+	if s.offsets == nil {
+		return NewString(s.str[begin:end])
+	}
+	return NewString(s.str[s.offsets[begin]:s.offsets[end]])
 }
 
 func (s *String) Index(ix int) Value {
-	panic("unused function")
+	if ix < 0 || ix >= s.Length() {
+		panic("index out of range")
+	}
+	if s.offsets == nil {
+		return NewString(s.str[ix : ix+1])
+	}
+	return NewString(s.str[s.offsets[ix]:s.offsets[ix+1]])
 }
 
 func (s *String) Length() int {
-	return len(*s)
+	if s.offsets == nil {
+		return len(s.str)
+	}
+	return len(s.offsets) - 1
 }
 
 func (s *String) Iterate(f func(int, Value) bool) {
-	panic("unused function")
+	for i, n := 0, s.Length(); i < n; i++ {
+		if f(i, s.Index(i)) {
+			return
+		}
+	}
 }
 
 type Bool bool
@@ -132,7 +244,7 @@ func (b *Bool) Equal(v Value) bool {
 	return *b0 == *b
 }
 func (b *Bool) Proto() *proto.Value {
-	return &proto.Value{&proto.Value_Boolean{&proto.Boolean{b.Bool()}}}
+	return &proto.Value{Value: &proto.Value_Boolean{&proto.Boolean{b.Bool()}}}
 }
 func (b *Bool) Unproto(pv *proto.Value) {
 	pa := pv.GetBoolean()
@@ -142,30 +254,6 @@ func (b *Bool) Unproto(pv *proto.Value) {
 	*b = Bool(pa.Boolean)
 }
 
-type Num int64
-
-func NewNum(i int64) *Num     { res := Num(i); return &res }
-func (*Num) Type() ValueType  { return TypeNumber }
-func (n *Num) String() string { return strconv.FormatInt(n.Num(), 10) }
-func (n *Num) Num() int64     { return int64(*n) }
-func (n *Num) Equal(v Value) bool {
-	n0, ok := v.(*Num)
-	if !ok {
-		return false
-	}
-	return *n0 == *n
-}
-func (n *Num) Proto() *proto.Value {
-	return &proto.Value{&proto.Value_Num{&proto.Num{n.Num()}}}
-}
-func (n *Num) Unproto(pv *proto.Value) {
-	pa := pv.GetNum()
-	if pa == nil {
-		return
-	}
-	*n = Num(pa.Num)
-}
-
 type Lambda struct {
 	Args   []Ident
 	Bodies []Value
@@ -184,53 +272,25 @@ func (l *Lambda) String() string {
 	}
 	return "(fn [" + strings.Join(l.Args, " ") + "] " + strings.Join(bodies, " ") + ")"
 }
+
+// Equal reports whether v is a lambda with the same arguments, bodies and
+// captured environment.
 func (l *Lambda) Equal(v Value) bool {
-	l0, ok := v.(*Lambda)
-	if !ok {
-		return false
-	}
-	if len(l0.Args) != len(l.Args) || len(l0.Bodies) != len(l.Bodies) { // TODO: add env
-		return false
-	}
-	for i, arg := range l.Args {
-		if arg != l0.Args[i] {
-			return false
-		}
-	}
-	for i, body := range l.Bodies {
-		if !body.Equal(l0.Bodies[i]) {
-			return false
-		}
-	}
-	return true
+	return hashEqual(l, v)
 }
 func (l *Lambda) Proto() *proto.Value {
-	bodies := make([]*proto.Value, len(l.Bodies))
-	for i, body := range l.Bodies {
-		bodies[i] = body.Proto()
-	}
-	return &proto.Value{&proto.Value_Lambda{&proto.Lambda{
-		l.Args,
-		bodies,
-		l.Env.Proto().GetEnv(),
-	}}}
+	return toProto(l)
 }
 func (l *Lambda) Unproto(pv *proto.Value) {
-	/*
-		pa := pv.GetLambda()
-		if pa == nil {
-			return
-		}
-		bodies := make([]Value, len(pa.Bodies))
-		for i, body := range pa.Bodies {
-			bodies[i]
-		}
-		*l = Lambda{
-			Args:   pa.Args,
-			Bodies: pa.Bodies,
-			Env:    pa.Env,
-		}
-	*/
+	pa := pv.GetLambda()
+	if pa == nil {
+		return
+	}
+	d := newProtoDecoder(pv)
+	res := d.lambda(pa)
+	if d.err == nil {
+		*l = *res
+	}
 }
 
 type LambdaRec struct {
@@ -254,115 +314,114 @@ func (l *LambdaRec) Equal(v Value) bool {
 }
 
 func (*LambdaRec) Type() ValueType { return TypeLambdaRec }
+func (l *LambdaRec) Proto() *proto.Value {
+	return toProto(l)
+}
 
 type Sequence interface {
 	Value
 	// Negative end means empty index: a[3:] -> a.Slice(3, -1)
+	// Slice and Index panic when out of range; check Length first.
 	Slice(int, int) Sequence
 	Index(int) Value
 	Length() int
 	Iterate(func(int, Value) bool)
 }
 
+// List is an immutable linked list; the empty list is nil. Each cell
+// caches the length of the list it starts, so construct lists with
+// NewList or Cons rather than by hand.
 type List struct {
 	Head Value
 	Tail *List
+	n    int
 }
 
 func NewList(vs ...Value) *List {
 	var top *List
 	for i := len(vs) - 1; i >= 0; i-- {
-		top = &List{
-			Head: vs[i],
-			Tail: top,
-		}
+		top = Cons(vs[i], top)
 	}
 	return top
 }
+
+// Cons returns the list with head in front of tail.
+func Cons(head Value, tail *List) *List {
+	return &List{head, tail, tail.Length() + 1}
+}
 func (*List) Type() ValueType { return TypeList }
-func (l *List) List() (res []Value) {
+func (l *List) List() []Value {
+	res := make([]Value, 0, l.Length())
 	for ; l != nil; l = l.Tail {
 		res = append(res, l.Head)
 	}
-	return
+	return res
 }
 func (l *List) String() string {
-	ll := l.List()
-	vs := make([]string, len(ll))
-	for i, v := range ll {
-		vs[i] = v.String()
+	vs := make([]string, 0, l.Length())
+	for ; l != nil; l = l.Tail {
+		vs = append(vs, l.Head.String())
 	}
 	return "(" + strings.Join(vs, " ") + ")"
 }
 func (l *List) Equal(v Value) bool {
 	l0, ok := v.(*List)
 	if !ok {
-		fmt.Println("111")
 		return false
 	}
-	ll, l0l := l.List(), l0.List()
-	if len(ll) != len(l0l) {
-		fmt.Println("222")
+	if l.Length() != l0.Length() {
 		return false
 	}
-	for i, v := range ll {
-		if !l0l[i].Equal(v) {
-			fmt.Println("333")
+	for ; l != nil; l, l0 = l.Tail, l0.Tail {
+		if !l0.Head.Equal(l.Head) {
 			return false
 		}
 	}
 	return true
 }
 func (l *List) Proto() *proto.Value {
-	var vs []*proto.Value
-	for ; l != nil; l = l.Tail {
-		vs = append(vs, l.Head.Proto())
-	}
-	return &proto.Value{&proto.Value_List{&proto.List{vs}}}
+	return toProto(l)
 }
 
+// Slice shares the tail of l when end is the end of the list and copies
+// the cells in front of end otherwise.
 func (l *List) Slice(begin, end int) Sequence {
-	if l == nil {
-		return nil
+	n := l.Length()
+	if end == -1 {
+		end = n
 	}
-	if end == 0 {
-		return nil
+	if begin < 0 || end < begin || end > n {
+		panic("slice bounds out of range")
 	}
 
-	// Slice all
-	if begin == 0 && end == -1 {
-		return l
+	for i := 0; i < begin; i++ {
+		l = l.Tail
 	}
-
-	// Drop
-	if end == -1 {
-		return l.Tail.Slice(begin-1, end)
+	if end == n {
+		return l
 	}
-
-	// Take
-	if begin == 0 {
-		return &List{
-			Head: l.Head,
-			Tail: l.Tail.Slice(0, end-1).(*List),
-		}
+	vs := make([]Value, end-begin)
+	for i := range vs {
+		vs[i], l = l.Head, l.Tail
 	}
-
-	// Normal slicing -> reduce to take
-	return l.Tail.Slice(begin-1, end-1)
+	return NewList(vs...)
 }
 
 func (l *List) Index(ix int) Value {
-	if ix == 0 {
-		return l.Head
+	if ix < 0 || ix >= l.Length() {
+		panic("index out of range")
+	}
+	for ; ix > 0; ix-- {
+		l = l.Tail
 	}
-	return l.Tail.Index(ix - 1)
+	return l.Head
 }
 
 func (l *List) Length() int {
 	if l == nil {
 		return 0
 	}
-	return l.Tail.Length() + 1
+	return l.n
 }
 
 func (l *List) Iterate(f func(ix int, v Value) bool) {
@@ -374,18 +433,36 @@ func (l *List) Iterate(f func(ix int, v Value) bool) {
 	}
 }
 
-// TODO: store in reversed order so we can prepend more efficient
-type Vector []Value
+// Vector is a persistent vector: updates return new vectors that share
+// structure with the old one, which is never modified. A vector is a view
+// of a range of its trie, so slicing is cheap too.
+type Vector struct {
+	t      *vtrie
+	off, n int
+}
 
-func NewVector(vs ...Value) *Vector { res := Vector(vs); return &res }
-func (*Vector) Type() ValueType     { return TypeVec }
-func (v *Vector) Vector() []Value   { return []Value(*v) }
-func (l *Vector) String() string {
-	vv := l.Vector()
-	vs := make([]string, len(vv))
-	for i, v := range vv {
-		vs[i] = v.String()
+func NewVector(vs ...Value) *Vector {
+	t := emptyTrie
+	for _, v := range vs {
+		t = t.push(v)
 	}
+	return &Vector{t, 0, len(vs)}
+}
+func (*Vector) Type() ValueType { return TypeVec }
+func (v *Vector) Vector() []Value {
+	res := make([]Value, 0, v.n)
+	v.Iterate(func(_ int, x Value) bool {
+		res = append(res, x)
+		return false
+	})
+	return res
+}
+func (l *Vector) String() string {
+	vs := make([]string, 0, l.n)
+	l.Iterate(func(_ int, v Value) bool {
+		vs = append(vs, v.String())
+		return false
+	})
 	return "[" + strings.Join(vs, " ") + "]"
 }
 func (l *Vector) Equal(v Value) bool {
@@ -393,99 +470,173 @@ func (l *Vector) Equal(v Value) bool {
 	if !ok {
 		return false
 	}
-	ll, l0l := l.Vector(), l0.Vector()
-	if len(ll) != len(l0l) {
+	if l.n != l0.n {
 		return false
 	}
-	for i, v := range ll {
-		if !l0l[i].Equal(v) {
-			return false
-		}
-	}
-	return true
+	res := true
+	l.Iterate(func(i int, v Value) bool {
+		res = l0.Index(i).Equal(v)
+		return !res
+	})
+	return res
 }
 func (v *Vector) Proto() *proto.Value {
-	vs := make([]*proto.Value, len(*v))
-	for i, v := range *v {
-		vs[i] = v.Proto()
-	}
-	return &proto.Value{&proto.Value_Vector{&proto.Vector{vs}}}
+	return toProto(v)
 }
 
 func (v *Vector) Slice(begin, end int) Sequence {
 	if end == -1 {
-		end = len(*v)
+		end = v.n
 	}
-	res := (*v)[begin:end]
-	return &res
+	if begin < 0 || end < begin || end > v.n {
+		panic("slice bounds out of range")
+	}
+	return &Vector{v.t, v.off + begin, end - begin}
 }
 
 func (v *Vector) Index(ix int) Value {
-	return (*v)[ix]
+	if ix < 0 || ix >= v.n {
+		panic("index out of range")
+	}
+	return v.t.get(v.off + ix)
 }
 
 func (v *Vector) Length() int {
-	return len(*v)
+	return v.n
 }
 
 func (v *Vector) Iterate(f func(int, Value) bool) {
-	for i, v := range *v {
-		if f(i, v) {
-			return
+	for i := 0; i < v.n; {
+		leaf := v.t.leaf(v.off + i)
+		for j := (v.off + i) & vmask; j < len(leaf) && i < v.n; j++ {
+			if f(i, leaf[j]) {
+				return
+			}
+			i++
 		}
 	}
 }
 
-// TODO: mv string hash
-type Dict map[Value]Value
+// Append returns a copy of v with x added at the end.
+func (v *Vector) Append(x Value) *Vector {
+	if v.t == nil {
+		return NewVector(x)
+	}
+	if v.off+v.n == v.t.cnt {
+		return &Vector{v.t.push(x), v.off, v.n + 1}
+	}
+	return &Vector{v.t.set(v.off+v.n, x), v.off, v.n + 1}
+}
+
+// Set returns a copy of v with the element at ix replaced by x.
+func (v *Vector) Set(ix int, x Value) *Vector {
+	if ix < 0 || ix >= v.n {
+		panic("index out of range")
+	}
+	return &Vector{v.t.set(v.off+ix, x), v.off, v.n}
+}
+
+// Dict is a persistent hash map keyed by the canonical encoding of its
+// keys, so structurally equal keys are the same key. Iteration is in key
+// order (see Compare).
+type Dict struct {
+	root *hnode
+	n    int
+}
+
+type dictEntry struct {
+	k, v Value
+}
 
 func NewDict(kvs ...Value) *Dict {
 	if len(kvs)%2 != 0 {
 		panic("odd number of arguments in NewDict()")
 	}
-	res := Dict(make(map[Value]Value))
+	res := &Dict{}
 	for i := 0; i < len(kvs); i += 2 {
-		res[kvs[i]] = kvs[i+1]
+		res.Set(kvs[i], kvs[i+1])
 	}
+	return res
+}
+func (*Dict) Type() ValueType { return TypeDict }
+func (d *Dict) Get(k Value) (Value, bool) {
+	ek := string(Encode(k))
+	e, ok := d.root.get(hashKey(ek), 0, ek)
+	return e.v, ok
+}
+
+// Set modifies d in place. Use it only on dicts under construction.
+func (d *Dict) Set(k Value, v Value) {
+	ek := string(Encode(k))
+	var added bool
+	d.root, added = d.root.insert(hashKey(ek), 0, ek, dictEntry{k, v})
+	if added {
+		d.n++
+	}
+}
+func (d *Dict) Len() int { return d.n }
+
+// Iterate calls f on every entry in key order until it returns true.
+func (d *Dict) Iterate(f func(k, v Value) bool) {
+	for _, e := range d.entries() {
+		if f(e.k, e.v) {
+			return
+		}
+	}
+}
+
+func (d *Dict) entries() []dictEntry {
+	res := make([]dictEntry, 0, d.n)
+	d.root.iterate(func(_ string, e dictEntry) { res = append(res, e) })
+	sort.Slice(res, func(i, j int) bool { return Compare(res[i].k, res[j].k) < 0 })
+	return res
+}
+
+// Insert returns a copy of d with k set to v.
+func (d *Dict) Insert(k Value, v Value) *Dict {
+	res := *d
+	res.Set(k, v)
 	return &res
 }
-func (*Dict) Type() ValueType                  { return TypeDict }
-func (d *Dict) Get(k Value) (v Value, ok bool) { v, ok = (*d)[k]; return }
-func (d *Dict) Set(k Value, v Value)           { (*d)[k] = v }
+
+// Delete returns a copy of d without k.
+func (d *Dict) Delete(k Value) *Dict {
+	ek := string(Encode(k))
+	root, removed := d.root.remove(hashKey(ek), 0, ek)
+	if !removed {
+		return d
+	}
+	return &Dict{root, d.n - 1}
+}
+
 func (d *Dict) String() string {
 	var elems []string
-	for k, v := range *d {
+	d.Iterate(func(k, v Value) bool {
 		elems = append(elems, k.String(), v.String())
-	}
+		return false
+	})
 	return "{" + strings.Join(elems, " ") + "}"
 }
 func (d *Dict) Equal(v Value) bool {
 	d0, ok := v.(*Dict)
 	if !ok {
-		fmt.Println("aaa")
 		return false
 	}
-	if len(*d) != len(*d0) {
-		fmt.Println("bbb")
+	if d.n != d0.n {
 		return false
 	}
-	strmap := make(map[string]Value)
-	for k, v := range *d {
-		fmt.Println("ccc")
-		strmap[k.String()] = v
-	}
-	for k, v := range *d0 {
-		if !strmap[k.String()].Equal(v) {
-			fmt.Println(k.String(), strmap[k.String()], v)
-			return false
+	res := true
+	d.root.iterate(func(_ string, e dictEntry) {
+		if res {
+			v0, ok := d0.Get(e.k)
+			res = ok && e.v.Equal(v0)
 		}
-	}
-	return true
+	})
+	return res
 }
 
 func (d *Dict) Proto() *proto.Value {
-	// XXX: sort kvpairs, translate to proto.KTypePair
-	panic("not implemented")
+	return toProto(d)
 }
 
 type PrimFn Ident
@@ -502,7 +653,7 @@ func (a *PrimFn) Equal(v Value) bool {
 	return a0.String() == a.String()
 }
 func (a *PrimFn) Proto() *proto.Value {
-	return &proto.Value{&proto.Value_PrimFn{&proto.PrimFn{a.Ident()}}}
+	return &proto.Value{Value: &proto.Value_PrimFn{&proto.PrimFn{a.Ident()}}}
 }
 
 type Ref uint64
@@ -519,7 +670,7 @@ func (r *Ref) Equal(v Value) bool {
 	return r0.Uint() == r.Uint()
 }
 func (r *Ref) Proto() *proto.Value {
-	return &proto.Value{&proto.Value_Ref{&proto.Ref{r.Uint()}}}
+	return &proto.Value{Value: &proto.Value_Ref{&proto.Ref{r.Uint()}}}
 }
 
 type State struct {
@@ -535,22 +686,31 @@ func NewState(env Env, refs *Intmap) *State {
 }
 func (*State) Type() ValueType { return TypeState }
 func (s *State) String() string {
-	return "" // XXX
+	refs := make([]string, 0, 2*len(s.Refs.m))
+	for _, ix := range s.Refs.keys() {
+		refs = append(refs, NewRef(ix).String(), s.Refs.m[ix].String())
+	}
+	return "(state " + s.Env.String() + " {" + strings.Join(refs, " ") + "})"
 }
 func (s *State) Equal(v Value) bool {
-	return false // XXX
+	return hashEqual(s, v)
 }
 func (s *State) Proto() *proto.Value {
-	/*
-		return &proto.Value{&proto.Value_State{
-			s.Env.Proto(),
-			s.Refs.Proto(),
-		}}
-	*/
-	return nil //XXX
+	return toProto(s)
 }
 
-func Unproto(pv *proto.Value) Value {
+// Unproto converts pv back to a value. It fails if pv is malformed, like
+// a dict with a key that is not set.
+func Unproto(pv *proto.Value) (Value, error) {
+	d := newProtoDecoder(pv)
+	res := d.value(pv)
+	if d.err != nil {
+		return nil, d.err
+	}
+	return res, nil
+}
+
+func (d *protoDecoder) value(pv *proto.Value) Value {
 	if pv == nil {
 		return nil
 	}
@@ -561,63 +721,56 @@ func Unproto(pv *proto.Value) Value {
 	case *proto.Value_Keyword:
 		return NewKeyword(NewIdent(pv.Keyword.Keyword))
 	case *proto.Value_String_:
-		return NewString(NewIdent(pv.String_.String_))
+		return NewString(string(pv.String_.String_))
 	case *proto.Value_Boolean:
 		return NewBool(pv.Boolean.Boolean)
 	case *proto.Value_Num:
-		return NewNum(pv.Num.Num)
+		if n := unprotoNum(pv.Num); n != nil {
+			return n
+		}
+		d.fail("invalid number %q", pv.Num.GetRat())
+		return nil
 	case *proto.Value_List:
 		l := pv.List
 		vs := make([]Value, len(l.Values))
 		for i, v := range l.Values {
-			vs[i] = Unproto(v)
+			vs[i] = d.elem(v)
 		}
 		return NewList(vs...)
 	case *proto.Value_Vector:
 		l := pv.Vector
 		vs := make([]Value, len(l.Values))
 		for i, v := range l.Values {
-			vs[i] = Unproto(v)
+			vs[i] = d.elem(v)
 		}
 		return NewVector(vs...)
 	case *proto.Value_PrimFn:
 		res := PrimFn(pv.PrimFn.Fn)
 		return &res
 	case *proto.Value_Dict:
-		d := pv.Dict
-		m := Dict(make(map[Value]Value))
-		for _, kvp := range d.Pairs {
-			m[Unproto(kvp.Key)] = Unproto(kvp.Value)
+		m := NewDict()
+		for _, kvp := range pv.Dict.Pairs {
+			k, v := d.elem(kvp.Key), d.elem(kvp.Value)
+			if k == nil || v == nil {
+				return nil
+			}
+			m.Set(k, v)
 		}
-		return &m
+		return m
 	case *proto.Value_Ref:
 		return NewRef(pv.Ref.Ref)
 	case *proto.Value_Lambda:
-		l := pv.Lambda
-		bodies := make([]Value, len(l.Bodies))
-		for i, body := range l.Bodies {
-			bodies[i] = Unproto(body)
-		}
-		return NewLambda(l.Args, bodies,
-			Unproto(&proto.Value{&proto.Value_Env{l.Env}}).(Env))
+		return d.lambda(pv.Lambda)
 	case *proto.Value_LambdaRec:
-		return NewLambdaRec(pv.LambdaRec.Self,
-			Unproto(&proto.Value{&proto.Value_Lambda{pv.LambdaRec.Lambda}}).(*Lambda))
+		return NewLambdaRec(pv.LambdaRec.Self, d.lambda(pv.LambdaRec.GetLambda()))
 	case *proto.Value_Env:
-		d := pv.Env
-		m := NewListEnv()
-		for _, kvp := range d.Pairs {
-			m.Set(kvp.Key, Unproto(kvp.Value))
-		}
-		return m
+		return d.env(pv.Env)
 	case *proto.Value_State:
-		/*
-			return NewState(
-				Unproto(&proto.Value{&proto.Value_Env{pv.State.Env}}).(Env),
-				Unproto(&proto.Value{&proto.Value_State{pv.State.State}}).(*State),
-			)
-		*/
-		return nil //XXX
+		env := d.env(pv.State.Env)
+		if env == nil {
+			env = NewEnv()
+		}
+		return NewState(env, d.intmap(pv.State.State))
 	default:
 		return nil
 	}
@@ -0,0 +1,515 @@
+package types
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	pb "github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mossid/dr-alice/types/proto"
+)
+
+func genIdent(r *rand.Rand) Ident {
+	return "x" + strconv.Itoa(r.Intn(10))
+}
+
+func genEnv(r *rand.Rand, depth int) Env {
+	var env Env
+	switch r.Intn(3) {
+	case 0:
+		env = NewListEnv()
+	case 1:
+		env = NewEnv()
+	default:
+		env = NewListEnv().CloneMutable()
+	}
+	for i := r.Intn(12); i > 0; i-- {
+		env = env.Set(genIdent(r), genValue(r, depth))
+	}
+	return env
+}
+
+func genLambda(r *rand.Rand, depth int) *Lambda {
+	args := make([]Ident, r.Intn(3))
+	for i := range args {
+		args[i] = genIdent(r)
+	}
+	return NewLambda(args, genValues(r, depth), genEnv(r, depth))
+}
+
+func genValues(r *rand.Rand, depth int) []Value {
+	res := make([]Value, r.Intn(4))
+	for i := range res {
+		res[i] = genValue(r, depth)
+	}
+	return res
+}
+
+// genValue returns a random value, nesting at most depth levels deep.
+func genValue(r *rand.Rand, depth int) Value {
+	n := 6
+	if depth > 0 {
+		n = 14
+	}
+	depth--
+
+	switch r.Intn(n) {
+	case 0:
+		return NewAtom(genIdent(r))
+	case 1:
+		return NewKeyword(genIdent(r))
+	case 2:
+		bz := make([]byte, r.Intn(8))
+		r.Read(bz)
+		return NewString(string(bz))
+	case 3:
+		return NewNumRat(big.NewRat(r.Int63()-r.Int63(), r.Int63n(1000)+1))
+	case 4:
+		return NewBool(r.Intn(2) == 0)
+	case 5:
+		return NewRef(uint64(r.Intn(100)))
+	case 6:
+		res := PrimFn("+")
+		return &res
+	case 7:
+		return NewList(genValues(r, depth)...)
+	case 8:
+		return NewVector(genValues(r, depth)...)
+	case 9:
+		res := NewDict()
+		for _, k := range genValues(r, depth) {
+			res.Set(k, genValue(r, depth))
+		}
+		return res
+	case 10:
+		return genLambda(r, depth)
+	case 11:
+		return NewLambdaRec(genIdent(r), genLambda(r, depth))
+	case 12:
+		return genEnv(r, depth)
+	default:
+		refs := NewIntmap()
+		for _, v := range genValues(r, depth) {
+			refs.Insert(v)
+		}
+		return NewState(genEnv(r, depth), refs)
+	}
+}
+
+func TestProtoRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		v := genValue(r, 3)
+
+		bz, err := pb.Marshal(v.Proto())
+		require.NoError(t, err, "Failed: %s", v)
+		var pv proto.Value
+		require.NoError(t, pb.Unmarshal(bz, &pv), "Failed: %s", v)
+		res, err := Unproto(&pv)
+		require.NoError(t, err, "Failed: %s", v)
+
+		require.Equal(t, v.Type(), res.Type(), "Wrong type: %s", v)
+		require.True(t, bytes.Equal(Encode(v), Encode(res)), "Not equal: %s and %s", v, res)
+		require.Equal(t, v.String(), res.String())
+		if env, ok := v.(Env); ok {
+			require.Equal(t, env.IsImmutable(), res.(Env).IsImmutable(), "Wrong mutability: %s", v)
+		}
+	}
+}
+
+func TestProtoIntmap(t *testing.T) {
+	refs := NewIntmap()
+	refs.Insert(NewNum(1))
+	refs.Insert(NewNum(2))
+	refs.Set(0, NewNum(3))
+
+	s, err := Unproto(NewState(NewListEnv(), refs).Proto())
+	require.NoError(t, err)
+	res := s.(*State).Refs
+	v, ok := res.Get(0)
+	require.True(t, ok)
+	require.True(t, NewNum(3).Equal(v))
+	require.Equal(t, uint64(2), res.Insert(NewNum(4)))
+}
+
+func TestProtoNil(t *testing.T) {
+	// refs and bindings may hold nil
+	refs := NewIntmap()
+	refs.Insert(nil)
+	for _, env := range []Env{NewListEnv(), NewEnv(), NewListEnv().CloneMutable()} {
+		s := NewState(env.Set("x", nil), refs)
+		res, err := Unproto(s.Proto())
+		require.NoError(t, err)
+		require.True(t, s.Equal(res), "Not equal: %s and %s", s, res)
+	}
+}
+
+func TestProtoMalformed(t *testing.T) {
+	num := NewNum(1).Proto()
+	env := func(root uint64) *proto.Value {
+		return &proto.Value{Value: &proto.Value_Env{&proto.Env{Root: root}}}
+	}
+	withNodes := func(pv *proto.Value, nodes ...*proto.EnvNode) *proto.Value {
+		pv.Nodes = nodes
+		return pv
+	}
+	slot := func(key string) *proto.EnvSlot {
+		return &proto.EnvSlot{Key: key, Value: num}
+	}
+	// the slot x hashes to at the root
+	xbit := uint32(1) << (hashKey("x") & hmask)
+
+	for i, pv := range []*proto.Value{
+		withNodes(env(1), &proto.EnvNode{Bitmap: 0xffffffff, Slots: []*proto.EnvSlot{slot("x")}}),
+		withNodes(env(1), &proto.EnvNode{Bitmap: xbit, Slots: []*proto.EnvSlot{slot("x"), slot("y")}}),
+		withNodes(env(1), &proto.EnvNode{Bitmap: xbit << 1, Slots: []*proto.EnvSlot{slot("x")}}),
+		withNodes(env(1), &proto.EnvNode{Bitmap: xbit, Slots: []*proto.EnvSlot{{Node: 1}}}),
+		env(2),
+		{Value: &proto.Value_Env{&proto.Env{Top: 1}}, Bindings: []*proto.Binding{{Name: "x", Next: 1}}},
+		{Value: &proto.Value_Dict{&proto.Dict{[]*proto.KVPair{{nil, num}}}}},
+		{Value: &proto.Value_Dict{&proto.Dict{[]*proto.KVPair{{num, &proto.Value{}}}}}},
+		{Value: &proto.Value_Vector{&proto.Vector{[]*proto.Value{num, nil}}}},
+		{Value: &proto.Value_List{&proto.List{[]*proto.Value{{}}}}},
+		{Value: &proto.Value_Num{&proto.Num{Rat: "x"}}},
+		{Value: &proto.Value_State{&proto.State{State: &proto.Intmap{[]*proto.IVPair{{1, num}}, 1}}}},
+	} {
+		_, err := Unproto(pv)
+		require.Error(t, err, "Not failed(%d)", i)
+	}
+
+	res, err := Unproto(withNodes(env(1), &proto.EnvNode{Bitmap: xbit, Slots: []*proto.EnvSlot{slot("x")}}))
+	require.NoError(t, err)
+	v, ok := res.(Env).Get("x")
+	require.True(t, ok)
+	require.True(t, NewNum(1).Equal(v))
+}
+
+func TestHash(t *testing.T) {
+	h := Hash(NewVector(NewNum(1), NewString("a"), NewKeyword("k")))
+	require.Equal(t, "3af329f44001b81c86cf435a676d5a5740161d2dfe231b5c212ec3276791f163", hex.EncodeToString(h[:]))
+
+	d0 := NewDict(NewKeyword("a"), NewNum(1), NewKeyword("b"), NewNum(2))
+	d1 := NewDict(NewKeyword("b"), NewNum(2), NewKeyword("a"), NewNum(1))
+	require.Equal(t, Hash(d0), Hash(d1))
+
+	env0 := NewListEnv().Set("a", NewNum(1)).Set("b", NewNum(2)).Set("a", NewNum(3))
+	env1 := NewListEnv().Set("b", NewNum(2)).Set("a", NewNum(3))
+	require.Equal(t, Hash(env0), Hash(env1))
+	require.True(t, env0.Equal(env1))
+	require.True(t, env0.Equal(env1.CloneMutable()))
+	require.False(t, env0.Equal(NewListEnv()))
+
+	l0 := NewLambda([]Ident{"x"}, []Value{NewAtom("x")}, env0)
+	l1 := NewLambda([]Ident{"x"}, []Value{NewAtom("x")}, env1.CloneMutable())
+	require.True(t, l0.Equal(l1))
+	require.False(t, l0.Equal(NewLambda(l0.Args, l0.Bodies, NewListEnv())))
+	require.False(t, l0.Equal(NewLambdaRec("f", l0)))
+
+	// hashes of immutable envs holding mutable values follow their changes
+	for _, env := range []Env{NewEnv(), NewListEnv()} {
+		m := NewListEnv().CloneMutable()
+		refs := NewIntmap()
+		s := NewState(NewEnv(), refs)
+		env = env.Set("m", m).Set("s", s)
+		l := NewLambda([]Ident{"x"}, []Value{NewAtom("x")}, env)
+		h, lh := Hash(env), Hash(l)
+		m.Set("a", NewNum(1))
+		require.NotEqual(t, h, Hash(env))
+		require.NotEqual(t, lh, Hash(l))
+		h, lh = Hash(env), Hash(l)
+		refs.Insert(NewNum(2))
+		require.NotEqual(t, h, Hash(env))
+		require.NotEqual(t, lh, Hash(l))
+		require.False(t, env.Equal(NewEnv().Set("m", NewListEnv().CloneMutable()).Set("s", NewState(NewEnv(), NewIntmap()))))
+	}
+}
+
+// closures defines n lambdas one after another in env, each closing over
+// the previous ones as top-level definitions do, and returns the last.
+func closures(env Env, n int, body Value) *Lambda {
+	var l *Lambda
+	for i := 0; i < n; i++ {
+		l = NewLambda([]Ident{"x"}, []Value{body}, env)
+		env = env.Set("f"+strconv.Itoa(i), l)
+	}
+	return l
+}
+
+func TestHashClosures(t *testing.T) {
+	// list envs rebuild the trie of their bindings to hash
+	for _, tc := range []struct {
+		env Env
+		n   int
+	}{{NewListEnv(), 100}, {NewEnv(), 2000}} {
+		l0 := closures(tc.env, tc.n, NewAtom("x"))
+		l1 := closures(tc.env, tc.n, NewAtom("x"))
+		require.True(t, l0.Equal(l1))
+		require.True(t, l0.Equal(l0))
+		require.False(t, l0.Equal(closures(tc.env, tc.n, NewAtom("y"))))
+		require.False(t, l0.Equal(closures(tc.env, tc.n-1, NewAtom("x"))))
+	}
+	require.True(t, closures(NewListEnv(), 100, NewAtom("x")).Equal(closures(NewEnv(), 100, NewAtom("x"))))
+}
+
+func TestProtoClosures(t *testing.T) {
+	// each closure captures the env of the one before, so copying envs
+	// into every closure would grow exponentially
+	for _, tc := range []struct {
+		env Env
+		n   int
+	}{{NewListEnv(), 100}, {NewEnv(), 1000}} {
+		size := func(n int) int {
+			l := closures(tc.env, n, NewAtom("x"))
+			s := NewState(tc.env.Set("f", l), NewIntmap())
+
+			bz, err := pb.Marshal(s.Proto())
+			require.NoError(t, err)
+			var pv proto.Value
+			require.NoError(t, pb.Unmarshal(bz, &pv))
+			res, err := Unproto(&pv)
+			require.NoError(t, err)
+			require.True(t, s.Equal(res))
+			return len(bz)
+		}
+		n, n2 := size(tc.n), size(2*tc.n)
+		require.True(t, n2 < n*5/2, "Size not linear: %d for %d closures, %d for twice as many", n, tc.n, n2)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	tcs := []struct {
+		Value Value
+		JSON  string
+	}{
+		{NewString("a\"b"), `"a\"b"`},
+		{NewBool(true), `true`},
+		{NewNum(-12), `-12`},
+		{NewNumRat(big.NewRat(-1, 4)), `-0.25`},
+		{NewNumRat(big.NewRat(1, 3)), `{"$rat":"1/3"}`},
+		{NewVector(NewNum(1), NewVector()), `[1,[]]`},
+		{NewList(NewNum(1), NewString("x")), `{"$list":[1,"x"]}`},
+		{NewList(), `{"$list":[]}`},
+		{NewKeyword("k"), `{"$keyword":"k"}`},
+		{NewAtom("x"), `{"$atom":"x"}`},
+		{NewDict(NewString("b"), NewNum(2), NewString("a"), NewNum(1)), `{"a":1,"b":2}`},
+		{NewDict(), `{}`},
+		{NewDict(NewString("$atom"), NewString("x")), `{"$dict":[["$atom","x"]]}`},
+		{NewDict(NewString("$a"), NewNum(1), NewString("$b"), NewNum(2)), `{"$a":1,"$b":2}`},
+		{NewDict(NewKeyword("a"), NewList(), NewNum(1), NewBool(false)), `{"$dict":[[{"$keyword":"a"},{"$list":[]}],[1,false]]}`},
+	}
+
+	for _, tc := range tcs {
+		bz, err := ToJSON(tc.Value)
+		require.NoError(t, err, "Failed: %s", tc.Value)
+		require.Equal(t, tc.JSON, string(bz))
+		res, err := FromJSON(bz)
+		require.NoError(t, err, "Failed: %s", tc.JSON)
+		require.True(t, tc.Value.Equal(res), "Not equal: %s and %s", tc.Value, res)
+	}
+
+	res, err := FromJSON([]byte(` {"x": [1e3, 0.5]} `))
+	require.NoError(t, err)
+	require.Equal(t, `{"x" [1000 0.5]}`, res.String())
+
+	for _, v := range []Value{NewRef(0), NewPrimFn(NewAtom("+")), NewListEnv(), NewVector(NewRef(1)),
+		NewLambda(nil, nil, NewListEnv()), NewState(NewListEnv(), NewIntmap())} {
+		_, err := ToJSON(v)
+		require.Error(t, err, "Not failed: %s", v)
+	}
+
+	for _, str := range []string{`null`, `[1, null]`, `{"$foo": 1}`, `{"$keyword": 1}`, `{"$dict": [[1]]}`, `1 2`, `[`} {
+		_, err := FromJSON([]byte(str))
+		require.Error(t, err, "Not failed: %s", str)
+	}
+}
+
+func TestVector(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	type version struct {
+		v     *Vector
+		model []Value
+	}
+	versions := []version{{NewVector(), nil}}
+
+	for i := 0; i < 2000; i++ {
+		old := versions[r.Intn(len(versions))]
+		var next version
+		switch n := len(old.model); {
+		case r.Intn(4) > 0 || n == 0:
+			x := NewNum(int64(i))
+			next = version{old.v.Append(x), append(append([]Value{}, old.model...), x)}
+		case r.Intn(2) == 0:
+			begin := r.Intn(n + 1)
+			end := begin + r.Intn(n-begin+1)
+			next = version{old.v.Slice(begin, end).(*Vector), old.model[begin:end]}
+		default:
+			ix := r.Intn(n)
+			model := append([]Value{}, old.model...)
+			model[ix] = NewString("x")
+			next = version{old.v.Set(ix, model[ix]), model}
+		}
+		versions = append(versions, next)
+	}
+
+	for _, ver := range versions {
+		require.Equal(t, len(ver.model), ver.v.Length())
+		require.True(t, NewVector(ver.model...).Equal(ver.v))
+		for i, x := range ver.model {
+			require.True(t, x.Equal(ver.v.Index(i)))
+		}
+	}
+}
+
+func TestDict(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	type version struct {
+		d     *Dict
+		model map[int64]Value
+	}
+	versions := []version{{NewDict(), map[int64]Value{}}}
+
+	for i := 0; i < 2000; i++ {
+		old := versions[r.Intn(len(versions))]
+		model := make(map[int64]Value, len(old.model))
+		for k, v := range old.model {
+			model[k] = v
+		}
+		k := r.Int63n(100)
+		var d *Dict
+		if r.Intn(3) > 0 {
+			model[k] = NewNum(int64(i))
+			d = old.d.Insert(NewNum(k), model[k])
+		} else {
+			delete(model, k)
+			d = old.d.Delete(NewNum(k))
+		}
+		versions = append(versions, version{d, model})
+	}
+
+	for _, ver := range versions {
+		require.Equal(t, len(ver.model), ver.d.Len())
+		for k := int64(0); k < 100; k++ {
+			v, ok := ver.d.Get(NewNum(k))
+			v0, ok0 := ver.model[k]
+			require.Equal(t, ok0, ok)
+			if ok {
+				require.True(t, v0.Equal(v))
+			}
+		}
+	}
+}
+
+func TestHAMTCollisions(t *testing.T) {
+	var n *hnode
+	for i := 0; i < 10; i++ {
+		ek := strconv.Itoa(i)
+		n, _ = n.insert(42, 0, ek, dictEntry{NewString(ek), NewNum(int64(i))})
+	}
+	for i := 0; i < 10; i++ {
+		e, ok := n.get(42, 0, strconv.Itoa(i))
+		require.True(t, ok)
+		require.True(t, NewNum(int64(i)).Equal(e.v))
+	}
+	_, ok := n.get(42, 0, "10")
+	require.False(t, ok)
+
+	for i := 0; i < 10; i++ {
+		var removed bool
+		n, removed = n.remove(42, 0, strconv.Itoa(i))
+		require.True(t, removed)
+	}
+	require.Nil(t, n)
+}
+
+func BenchmarkVectorAppend(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		v := NewVector()
+		for j := 0; j < 1000; j++ {
+			v = v.Append(NewNum(int64(j)))
+		}
+	}
+}
+
+func BenchmarkDictInsert(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		d := NewDict()
+		for j := 0; j < 1000; j++ {
+			d = d.Insert(NewNum(int64(j)), NewNum(int64(j)))
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	var model []Value
+	for i := 0; i < 20; i++ {
+		model = append(model, NewNum(int64(i)))
+	}
+	l := NewList(model...)
+
+	for begin := 0; begin <= len(model); begin++ {
+		for end := begin; end <= len(model); end++ {
+			res := l.Slice(begin, end)
+			require.Equal(t, end-begin, res.Length())
+			require.True(t, NewList(model[begin:end]...).Equal(res))
+		}
+		require.True(t, NewList(model[begin:]...).Equal(l.Slice(begin, -1)))
+	}
+	for i, v := range model {
+		require.True(t, v.Equal(l.Index(i)))
+	}
+	require.Panics(t, func() { l.Index(20) })
+	require.Panics(t, func() { l.Slice(0, 21) })
+	require.Equal(t, 0, (*List)(nil).Slice(0, -1).Length())
+
+	long := NewList(make([]Value, 1000000)...)
+	require.Equal(t, 1000000, long.Length())
+	require.Equal(t, 999999, Cons(NewNum(0), long).Slice(2, -1).Length())
+}
+
+func TestString(t *testing.T) {
+	// bytes that are not valid UTF-8 are characters of their own, kept as is
+	for _, tc := range []struct {
+		str   string
+		chars []string
+	}{
+		{"hello", []string{"h", "e", "l", "l", "o"}},
+		{"héllo", []string{"h", "é", "l", "l", "o"}},
+		{"a\xffb", []string{"a", "\xff", "b"}},
+		{"é\xff", []string{"é", "\xff"}},
+		{"\xe2\x82", []string{"\xe2", "\x82"}},
+		{"", nil},
+	} {
+		s := NewString(tc.str)
+		require.Equal(t, len(tc.chars), s.Length(), "Wrong length: %q", tc.str)
+		for i, c := range tc.chars {
+			require.Equal(t, c, s.Index(i).(*String).Str(), "Wrong index: %q", tc.str)
+		}
+		for begin := 0; begin <= len(tc.chars); begin++ {
+			for end := begin; end <= len(tc.chars); end++ {
+				require.Equal(t, strings.Join(tc.chars[begin:end], ""), s.Slice(begin, end).(*String).Str(), "Wrong slice: %q", tc.str)
+			}
+		}
+		var chars []string
+		s.Iterate(func(_ int, v Value) bool {
+			chars = append(chars, v.(*String).Str())
+			return false
+		})
+		require.Equal(t, tc.chars, chars, "Wrong iteration: %q", tc.str)
+		require.True(t, s.Equal(s.Slice(0, -1)), "Slice not equal: %q", tc.str)
+		require.Panics(t, func() { s.Index(len(tc.chars)) })
+		require.Panics(t, func() { s.Slice(0, len(tc.chars)+1) })
+	}
+}
+
+func BenchmarkStringIndex(b *testing.B) {
+	s := NewString(strings.Repeat("é", 10000))
+	for i := 0; i < b.N; i++ {
+		s.Index(i % 10000)
+	}
+}